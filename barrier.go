@@ -0,0 +1,96 @@
+package volley
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// FireBarrier fires several transports at the same instant, e.g. one per target host.
+//
+// Fire waits until every registered transport is armed, then fires all of them with one
+// shared trigger instant: each transport releases its batch from its own goroutine, and
+// with SpinLead set on the transports (the largest one is used), all of them spin until
+// that instant before writing. Registrations outlive Reset(): a transport stays in the
+// barrier for its next batches.
+//
+// All methods are safe for concurrent use. A transport fired by other means in the
+// meantime is not fired twice, so a second Fire of the same batches releases nothing.
+type FireBarrier struct {
+	mu      sync.Mutex
+	members []barrierMember
+}
+
+type barrierMember struct {
+	t    *Transport
+	want int
+}
+
+// NewFireBarrier creates an empty barrier.
+func NewFireBarrier() *FireBarrier {
+	return &FireBarrier{}
+}
+
+// Add registers t, which counts as ready once Wait(ctx, want) on it would return.
+func (b *FireBarrier) Add(t *Transport, want int) {
+	b.mu.Lock()
+	b.members = append(b.members, barrierMember{t: t, want: want})
+	b.mu.Unlock()
+}
+
+// Wait blocks until every registered transport is armed (see Transport.Wait).
+// It returns the first error, in registration order.
+func (b *FireBarrier) Wait(ctx context.Context) error {
+	for _, m := range b.snapshot() {
+		if err := m.t.Wait(ctx, m.want); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Fire waits until every registered transport is armed (see Wait) and their gate tokens
+// are done (see AddToGate), then fires them all at one trigger instant. It returns once
+// every transport has released its batch, with the number of connections released.
+// If Wait fails, nothing is fired and its error is returned.
+func (b *FireBarrier) Fire(ctx context.Context) (int, error) {
+	members := b.snapshot()
+	if len(members) == 0 {
+		return 0, nil
+	}
+	if err := b.Wait(ctx); err != nil {
+		return 0, err
+	}
+
+	var lead time.Duration
+	for _, m := range members {
+		m.t.waitGate()
+		if m.t.SpinLead > lead {
+			lead = m.t.SpinLead
+		}
+	}
+	trigger := members[0].t.now().Add(lead)
+
+	var released int
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, m := range members {
+		wg.Add(1)
+		go func(t *Transport) {
+			defer wg.Done()
+			n := t.fireAt(trigger)
+			mu.Lock()
+			released += n
+			mu.Unlock()
+		}(m.t)
+	}
+	wg.Wait()
+	return released, nil
+}
+
+// snapshot returns a copy of the registered members.
+func (b *FireBarrier) snapshot() []barrierMember {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]barrierMember(nil), b.members...)
+}
@@ -0,0 +1,68 @@
+package volley
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestFireBarrier(t *testing.T) {
+	a, b := NewTestTransport(pipeDial), NewTestTransport(pipeDial)
+	fb := NewFireBarrier()
+	fb.Add(a, 2)
+	fb.Add(b, 2)
+
+	var errs []<-chan error
+	for i := 0; i < 2; i++ {
+		errs = append(errs, get(a, "http://a.test/"))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	type fired struct {
+		n   int
+		err error
+	}
+	done := make(chan fired, 1)
+	go func() {
+		n, err := fb.Fire(ctx)
+		done <- fired{n, err}
+	}()
+
+	// Fire is gated on every transport being armed: b has nothing held yet
+	waitFor(t, "a to hold", func() bool { return a.Stats().Held == 2 })
+	select {
+	case f := <-done:
+		t.Fatalf("Fire returned %+v before b was armed", f)
+	case <-time.After(20 * time.Millisecond):
+	}
+	if a.Stats().Fired {
+		t.Fatal("a fired before b was armed")
+	}
+
+	for i := 0; i < 2; i++ {
+		errs = append(errs, get(b, "http://b.test/"))
+	}
+	f := <-done
+	if f.err != nil || f.n != 4 {
+		t.Fatalf("Fire = %d, %v, want 4, nil", f.n, f.err)
+	}
+
+	// Fire returns once the releases are done, from one shared trigger instant
+	if ra, rb := a.Stats().Released, b.Stats().Released; ra != 2 || rb != 2 {
+		t.Fatalf("released %d and %d when Fire returned, want 2 and 2", ra, rb)
+	}
+	if ta, tb := a.fireTime(), b.fireTime(); !ta.Equal(tb) {
+		t.Fatalf("trigger instants differ: %v and %v", ta, tb)
+	}
+	for _, errc := range errs {
+		if err := recvErr(t, errc); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The batches already fired: a second Fire releases nothing
+	if n, err := fb.Fire(ctx); n != 0 || err != nil {
+		t.Fatalf("second Fire = %d, %v, want 0, nil", n, err)
+	}
+}
@@ -0,0 +1,59 @@
+package volley
+
+import "time"
+
+// clock is the source of time for the timers and timestamps of a Transport, so tests can
+// substitute a fake one (see withClock). Every instant the Transport records or waits for
+// comes from it: fire triggers, release instants, timeouts and the waits of SpinLead,
+// FireStaggered and FireWaves.
+type clock interface {
+	Now() time.Time
+	NewTimer(d time.Duration) timer
+}
+
+// timer is the part of *time.Timer used by the Transport.
+type timer interface {
+	C() <-chan time.Time
+	Stop() bool
+}
+
+// realClock is the clock of the time package.
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
+func (realClock) NewTimer(d time.Duration) timer { return realTimer{time.NewTimer(d)} }
+
+type realTimer struct {
+	*time.Timer
+}
+
+func (r realTimer) C() <-chan time.Time { return r.Timer.C }
+
+// withClock replaces the clock of the transport.
+func withClock(c clock) Option {
+	return func(t *Transport) {
+		t.clock = c
+	}
+}
+
+// now returns the current time of the transport's clock.
+func (t *Transport) now() time.Time {
+	if t.clock == nil {
+		return time.Now()
+	}
+	return t.clock.Now()
+}
+
+// since returns the time elapsed since at on the transport's clock.
+func (t *Transport) since(at time.Time) time.Duration {
+	return t.now().Sub(at)
+}
+
+// newTimer starts a timer of the transport's clock.
+func (t *Transport) newTimer(d time.Duration) timer {
+	if t.clock == nil {
+		return realClock{}.NewTimer(d)
+	}
+	return t.clock.NewTimer(d)
+}
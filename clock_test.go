@@ -0,0 +1,109 @@
+package volley
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock is a clock whose time only moves on Advance.
+type fakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+}
+
+type fakeTimer struct {
+	at time.Time
+	c  chan time.Time
+}
+
+func (f *fakeTimer) C() <-chan time.Time { return f.c }
+
+func (f *fakeTimer) Stop() bool { return true }
+
+func (f *fakeClock) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+func (f *fakeClock) NewTimer(d time.Duration) timer {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	ft := &fakeTimer{at: f.now.Add(d), c: make(chan time.Time, 1)}
+	f.timers = append(f.timers, ft)
+	return ft
+}
+
+// Advance moves the time forward by d, firing the timers due by then.
+func (f *fakeClock) Advance(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.now = f.now.Add(d)
+	kept := f.timers[:0]
+	for _, ft := range f.timers {
+		if ft.at.After(f.now) {
+			kept = append(kept, ft)
+			continue
+		}
+		ft.c <- f.now
+	}
+	f.timers = kept
+}
+
+// pending returns the number of timers not fired yet.
+func (f *fakeClock) pending() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.timers)
+}
+
+func TestFireAfterFakeClock(t *testing.T) {
+	fc := &fakeClock{now: time.Unix(1000, 0)}
+	vt := NewTransport(withClock(fc))
+
+	vt.FireAfter(time.Hour)
+	waitFor(t, "the FireAfter timer", func() bool { return fc.pending() == 1 })
+	fc.Advance(time.Hour - time.Second)
+	if vt.Stats().Fired {
+		t.Fatal("fired before the deadline")
+	}
+
+	fc.Advance(time.Second)
+	waitFor(t, "the fire", func() bool { return vt.Stats().Fired })
+}
+
+func TestReleaseTimesFakeClock(t *testing.T) {
+	start := time.Unix(1000, 0)
+	fc := &fakeClock{now: start}
+	vt := NewTransport(withClock(fc))
+	for i := 0; i < 3; i++ {
+		holdPipe(t, vt)
+	}
+
+	done := make(chan []*StraddleConn)
+	go func() { done <- vt.FireStaggered(time.Second) }()
+
+	// Each release after the first waits for its slot on the fake clock
+	for i := 1; i < 3; i++ {
+		waitFor(t, "the staggered release timer", func() bool { return fc.pending() == 1 })
+		fc.Advance(time.Second)
+	}
+	if order := <-done; len(order) != 3 {
+		t.Fatalf("FireStaggered released %d connections, want 3", len(order))
+	}
+
+	got := vt.ReleaseTimings()
+	if len(got) != 3 {
+		t.Fatalf("ReleaseTimings = %v, want 3 instants", got)
+	}
+	for i, at := range got {
+		if want := start.Add(time.Duration(i) * time.Second); !at.Equal(want) {
+			t.Errorf("release %d at %v, want %v", i, at, want)
+		}
+	}
+	if min, max, _ := vt.FireJitter(); min != 0 || max != 2*time.Second {
+		t.Errorf("FireJitter = %v..%v, want 0s..2s", min, max)
+	}
+}
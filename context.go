@@ -0,0 +1,50 @@
+package volley
+
+import "context"
+
+// labelKey is the context key for the connection label.
+type labelKey struct{}
+
+// passthroughKey is the context key of the passthrough flag.
+type passthroughKey struct{}
+
+// WithLabel returns a copy of ctx carrying a label for the connection dialed on its behalf.
+// net/http passes the request context down to the dialer, so
+//
+//	req = req.WithContext(volley.WithLabel(ctx, "req-7"))
+//
+// tags the StraddleConn created for req with "req-7". It serves as a correlation ID: the
+// label is echoed verbatim in StraddleConn.Label, Event.Label, RequestTiming.Label,
+// Stats.HeldLabels and the debug log lines, so client and server events can be lined up.
+// Labels need not be unique.
+func WithLabel(ctx context.Context, label string) context.Context {
+	return context.WithValue(ctx, labelKey{}, label)
+}
+
+// WithRequestID is WithLabel under the name of its main use: it tags the connection
+// dialed for the request of ctx with id, echoed verbatim wherever the label is.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return WithLabel(ctx, id)
+}
+
+// labelFrom returns the label stored in ctx, or "".
+func labelFrom(ctx context.Context) string {
+	label, _ := ctx.Value(labelKey{}).(string)
+	return label
+}
+
+// WithPassthrough returns a copy of ctx marking the request as a passthrough: the
+// connection dialed for it is a plain one, neither straddled nor counted, so the request
+// is sent right away, e.g. as a control request alongside a held batch.
+//
+// The choice is made when the connection is dialed. With keep-alives enabled, net/http
+// may hand a pooled connection to the request instead, straddled or not.
+func WithPassthrough(ctx context.Context) context.Context {
+	return context.WithValue(ctx, passthroughKey{}, true)
+}
+
+// passthrough reports whether ctx was marked by WithPassthrough.
+func passthrough(ctx context.Context) bool {
+	v, _ := ctx.Value(passthroughKey{}).(bool)
+	return v
+}
@@ -0,0 +1,106 @@
+package volley
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPassthroughAmongHeld(t *testing.T) {
+	var served int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&served, 1)
+	}))
+	defer srv.Close()
+
+	const held = 3
+	vt := NewTransport()
+	defer vt.Close()
+	var errcs []<-chan error
+	for i := 0; i < held; i++ {
+		errcs = append(errcs, get(vt, srv.URL))
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := vt.Wait(ctx, held); err != nil {
+		t.Fatal(err)
+	}
+
+	// The control request goes through while the others are held
+	req, _ := http.NewRequestWithContext(WithPassthrough(ctx), http.MethodGet, srv.URL, nil)
+	resp, err := (&http.Client{Transport: vt}).Do(req)
+	if err != nil {
+		t.Fatalf("passthrough request: %v", err)
+	}
+	resp.Body.Close()
+	if got := atomic.LoadInt32(&served); got != 1 {
+		t.Fatalf("served %d before fire, want 1", got)
+	}
+	if s := vt.Stats(); s.Held != held || s.Alive != held {
+		t.Fatalf("stats = %+v, want %d held and alive", s, held)
+	}
+
+	vt.Fire()
+	for _, errc := range errcs {
+		if err := recvErr(t, errc); err != nil {
+			t.Fatalf("held request: %v", err)
+		}
+	}
+	if got := atomic.LoadInt32(&served); got != held+1 {
+		t.Fatalf("served %d after fire, want %d", got, held+1)
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	vt := NewTransport()
+	defer vt.Close()
+	events := vt.Events()
+	// IDs are echoed verbatim, duplicates included
+	ids := []string{"req-1", "req-1"}
+	errcs := make([]chan error, len(ids))
+	for i, id := range ids {
+		req, err := http.NewRequestWithContext(WithRequestID(context.Background(), id), http.MethodGet, srv.URL, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		errcs[i] = make(chan error, 1)
+		go func(errc chan<- error) {
+			resp, err := vt.RoundTrip(req)
+			if err == nil {
+				resp.Body.Close()
+			}
+			errc <- err
+		}(errcs[i])
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := vt.Wait(ctx, len(ids)); err != nil {
+		t.Fatal(err)
+	}
+	if got := vt.Stats().HeldLabels; len(got) != 2 || got[0] != "req-1" || got[1] != "req-1" {
+		t.Fatalf("HeldLabels = %q, want %q", got, ids)
+	}
+	for held := 0; held < len(ids); {
+		ev := <-events
+		if ev.Type != EventConnHeld {
+			continue
+		}
+		if ev.Label != "req-1" {
+			t.Fatalf("held event label %q, want %q", ev.Label, "req-1")
+		}
+		held++
+	}
+
+	vt.Fire()
+	for _, errc := range errcs {
+		if err := recvErr(t, errc); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
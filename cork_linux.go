@@ -0,0 +1,25 @@
+package volley
+
+import (
+	"net"
+	"syscall"
+)
+
+// setCork sets TCP_CORK on the TCP connection under c, if any (see Transport.TCPCork).
+func setCork(c net.Conn, on bool) {
+	tc, ok := tcpConn(c)
+	if !ok {
+		return
+	}
+	rc, err := tc.SyscallConn()
+	if err != nil {
+		return
+	}
+	v := 0
+	if on {
+		v = 1
+	}
+	rc.Control(func(fd uintptr) {
+		syscall.SetsockoptInt(int(fd), syscall.IPPROTO_TCP, syscall.TCP_CORK, v)
+	})
+}
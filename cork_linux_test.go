@@ -0,0 +1,116 @@
+package volley
+
+import (
+	"context"
+	"io"
+	"net"
+	"syscall"
+	"testing"
+)
+
+// sockopt reads the IPPROTO_TCP option opt of the TCP connection under c.
+func sockopt(t *testing.T, c net.Conn, opt int) int {
+	t.Helper()
+	tc, ok := tcpConn(c)
+	if !ok {
+		t.Fatalf("%T is not a TCP connection", c)
+	}
+	rc, err := tc.SyscallConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var v int
+	rc.Control(func(fd uintptr) {
+		v, err = syscall.GetsockoptInt(int(fd), syscall.IPPROTO_TCP, opt)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+// corked reports whether TCP_CORK is set on the TCP connection under c.
+func corked(t *testing.T, c net.Conn) bool {
+	t.Helper()
+	return sockopt(t, c, syscall.TCP_CORK) == 1
+}
+
+func TestTCPCork(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		if c, err := ln.Accept(); err == nil {
+			accepted <- c
+		}
+	}()
+
+	vt := NewTransport(WithTCPCork(true))
+	c, err := vt.Transport.DialContext(context.Background(), "tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	sc := c.(*StraddleConn)
+	defer sc.Close()
+	srv := <-accepted
+	defer srv.Close()
+
+	if corked(t, sc.Conn) {
+		t.Fatal("corked before the first write")
+	}
+	sc.Write([]byte("abc"))
+	sc.Write([]byte("def"))
+	if !corked(t, sc.Conn) {
+		t.Fatal("not corked while holding")
+	}
+	// The corked bytes still go out, at the latest when the kernel's cork timer expires
+	if got := readN(t, srv, 5); got != "abcde" {
+		t.Fatalf("sent before fire %q, want %q", got, "abcde")
+	}
+
+	vt.Fire()
+	if corked(t, sc.Conn) {
+		t.Fatal("still corked after the fire")
+	}
+	buf := make([]byte, 8)
+	n, err := io.ReadAtLeast(srv, buf, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(buf[:n]); got != "f" {
+		t.Fatalf("released %q, want %q", got, "f")
+	}
+}
+
+func TestNoDelay(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer c.Close()
+		}
+	}()
+
+	for _, noDelay := range []bool{true, false} {
+		vt := NewTransport(WithNoDelay(noDelay))
+		c, err := vt.Transport.DialContext(context.Background(), "tcp", ln.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := sockopt(t, c.(*StraddleConn).Conn, syscall.TCP_NODELAY) != 0
+		c.Close()
+		if got != noDelay {
+			t.Fatalf("WithNoDelay(%v): TCP_NODELAY is %v on the socket", noDelay, got)
+		}
+	}
+}
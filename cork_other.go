@@ -0,0 +1,8 @@
+//go:build !linux
+
+package volley
+
+import "net"
+
+// setCork is a no-op: TCP_CORK is specific to Linux (see Transport.TCPCork).
+func setCork(c net.Conn, on bool) {}
@@ -0,0 +1,95 @@
+package volley
+
+import (
+	"context"
+	"net"
+	"sync"
+)
+
+// DNSPolicy selects how dials resolve the target host (see Transport.DNS).
+type DNSPolicy int
+
+const (
+	// DNSPerDial lets every dial resolve the host itself (the default).
+	DNSPerDial DNSPolicy = iota
+	// DNSPinFirst resolves each host once per batch and dials its first address.
+	DNSPinFirst
+	// DNSRoundRobin resolves each host once per batch and rotates over its addresses.
+	DNSRoundRobin
+)
+
+// dnsCache holds the addresses resolved for the current batch, by host.
+type dnsCache struct {
+	mu      sync.Mutex
+	entries map[string]*dnsEntry
+}
+
+// dnsEntry is the lookup of one host. done is closed once ips and err are set,
+// so concurrent dials to the host share a single lookup.
+type dnsEntry struct {
+	done chan struct{}
+	ips  []string
+	err  error
+	next int
+}
+
+// resolve returns the address to dial for addr ("host:port") under policy.
+// IP literals and DNSPerDial are returned unchanged.
+func (c *dnsCache) resolve(ctx context.Context, r *net.Resolver, policy DNSPolicy, addr string) (string, error) {
+	if policy == DNSPerDial {
+		return addr, nil
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil || net.ParseIP(host) != nil {
+		return addr, nil
+	}
+
+	c.mu.Lock()
+	if c.entries == nil {
+		c.entries = make(map[string]*dnsEntry)
+	}
+	e, ok := c.entries[host]
+	if !ok {
+		e = &dnsEntry{done: make(chan struct{})}
+		c.entries[host] = e
+	}
+	c.mu.Unlock()
+
+	if !ok {
+		e.ips, e.err = r.LookupHost(ctx, host)
+		if e.err != nil {
+			// Do not cache failures: the next dial looks up again
+			c.mu.Lock()
+			if c.entries[host] == e {
+				delete(c.entries, host)
+			}
+			c.mu.Unlock()
+		}
+		close(e.done)
+	}
+
+	select {
+	case <-e.done:
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+	if e.err != nil {
+		return "", e.err
+	}
+
+	ip := e.ips[0]
+	if policy == DNSRoundRobin {
+		c.mu.Lock()
+		ip = e.ips[e.next%len(e.ips)]
+		e.next++
+		c.mu.Unlock()
+	}
+	return net.JoinHostPort(ip, port), nil
+}
+
+// reset drops the cached lookups.
+func (c *dnsCache) reset() {
+	c.mu.Lock()
+	c.entries = nil
+	c.mu.Unlock()
+}
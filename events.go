@@ -0,0 +1,102 @@
+package volley
+
+import (
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// EventType identifies a transition in the lifecycle of a Transport.
+type EventType int
+
+const (
+	// EventDialStarted is emitted when a tracked dial starts.
+	EventDialStarted EventType = iota
+	// EventConnAlive is emitted when a connection is established (or rejoins a batch, see WithKeepAlive).
+	EventConnAlive
+	// EventConnHeld is emitted when a connection starts holding data.
+	EventConnHeld
+	// EventFired is emitted when the batch fires (by Fire or FireStaggered),
+	// once the held connections were released.
+	EventFired
+	// EventConnClosed is emitted when a connection is closed.
+	EventConnClosed
+)
+
+func (e EventType) String() string {
+	switch e {
+	case EventDialStarted:
+		return "DialStarted"
+	case EventConnAlive:
+		return "ConnAlive"
+	case EventConnHeld:
+		return "ConnHeld"
+	case EventFired:
+		return "Fired"
+	case EventConnClosed:
+		return "ConnClosed"
+	default:
+		return "EventType(" + strconv.Itoa(int(e)) + ")"
+	}
+}
+
+// Event describes a lifecycle transition of a Transport.
+type Event struct {
+	Type EventType
+	Time time.Time
+	// Label and Addr identify the connection (see StraddleConn); both are empty for EventFired.
+	// EventDialStarted only carries Addr.
+	Label string
+	Addr  string
+}
+
+// Events returns the channel on which lifecycle events are delivered. Every call returns
+// the same channel; events are only recorded from the first call on. The channel is
+// closed by Close.
+//
+// The channel holds EventBuffer events. When it is full, new events are dropped unless
+// EventsBlock is set, in which case the emitting goroutine waits for the consumer:
+// this may delay dials, writes and Fire itself.
+func (t *Transport) Events() <-chan Event {
+	t.eventsOnce.Do(func() {
+		n := t.EventBuffer
+		if n < 0 {
+			n = 0
+		}
+		t.eventsAtom.Store(make(chan Event, n))
+	})
+	return t.eventsAtom.Load().(chan Event)
+}
+
+// EventsDropped returns the number of events dropped because the channel was full.
+func (t *Transport) EventsDropped() int {
+	return int(atomic.LoadInt32(&t.eventsDropped))
+}
+
+// emit delivers an event if Events() was called.
+func (t *Transport) emit(typ EventType, label, addr string) {
+	ch, ok := t.eventsAtom.Load().(chan Event)
+	if !ok {
+		return
+	}
+
+	t.eventsMu.RLock()
+	defer t.eventsMu.RUnlock()
+	if atomic.LoadInt32(&t.closed) == 1 {
+		return
+	}
+
+	e := Event{Type: typ, Time: t.now(), Label: label, Addr: addr}
+	if t.EventsBlock {
+		select {
+		case ch <- e:
+		case <-t.closeCh:
+		}
+		return
+	}
+	select {
+	case ch <- e:
+	default:
+		atomic.AddInt32(&t.eventsDropped, 1)
+	}
+}
@@ -0,0 +1,57 @@
+package volley_test
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	volley "github.com/ejfkdev/go-volley"
+)
+
+func ExampleNewTestTransport() {
+	vt := volley.NewTestTransport(func(ctx context.Context) (net.Conn, error) {
+		client, server := net.Pipe()
+		go func() {
+			// A one-request server on the other end of the pipe
+			defer server.Close()
+			req, err := http.ReadRequest(bufio.NewReader(server))
+			if err != nil {
+				return
+			}
+			req.Body.Close()
+			fmt.Fprint(server, "HTTP/1.1 200 OK\r\nContent-Length: 0\r\nConnection: close\r\n\r\n")
+		}()
+		return client, nil
+	})
+	client := &http.Client{Transport: vt}
+
+	const want = 3
+	var wg sync.WaitGroup
+	for i := 0; i < want; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if resp, err := client.Get("http://volley.test/"); err == nil {
+				resp.Body.Close()
+			}
+		}()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := vt.Wait(ctx, want); err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Println("held:", vt.Stats().Held)
+	n, _ := vt.Fire()
+	fmt.Println("released:", n)
+	wg.Wait()
+	// Output:
+	// held: 3
+	// released: 3
+}
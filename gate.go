@@ -0,0 +1,54 @@
+package volley
+
+import "sync"
+
+// GateToken holds back Fire() until Done is called (see Transport.AddToGate).
+type GateToken struct {
+	t    *Transport
+	once sync.Once
+}
+
+// AddToGate returns a token that Fire() waits for: a Fire call blocks until every
+// outstanding token is done. It lets external work, such as creating a resource over
+// one request before the others are released, slot in between "armed" and "fired"
+// without touching the counters.
+//
+// Tokens are independent of Wait, which still only reports the state of the
+// connections: the usual sequence is AddToGate, send the requests, Wait, do the work,
+// Done, while a Fire (or WaitAndFire) call made in the meantime blocks on the gate.
+// Tokens are not tied to a batch: Reset leaves them outstanding.
+func (t *Transport) AddToGate() *GateToken {
+	t.gateMu.Lock()
+	if t.gateN == 0 {
+		t.gateCh = make(chan struct{})
+	}
+	t.gateN++
+	t.gateMu.Unlock()
+	return &GateToken{t: t}
+}
+
+// Done releases the token. Calling it more than once has no further effect.
+func (g *GateToken) Done() {
+	g.once.Do(func() {
+		t := g.t
+		t.gateMu.Lock()
+		t.gateN--
+		if t.gateN == 0 {
+			close(t.gateCh)
+		}
+		t.gateMu.Unlock()
+	})
+}
+
+// waitGate blocks until no gate token is outstanding.
+func (t *Transport) waitGate() {
+	t.gateMu.Lock()
+	ch := t.gateCh
+	n := t.gateN
+	t.gateMu.Unlock()
+
+	if n > 0 {
+		t.logf("fire waiting for gate", "tokens", n)
+		<-ch
+	}
+}
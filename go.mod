@@ -1,3 +1,3 @@
 module github.com/ejfkdev/go-volley
 
-go 1.14
+go 1.27
@@ -0,0 +1,383 @@
+package volley
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// HTTP/2 frame types and flags inspected by H2Conn (RFC 9113, section 6).
+const (
+	h2FrameData         = 0x0
+	h2FrameHeaders      = 0x1
+	h2FrameContinuation = 0x9
+
+	h2FlagEndStream = 0x1
+
+	h2FrameHeaderLen = 9
+)
+
+// h2ClientPreface is written by the client before the first frame.
+const h2ClientPreface = "PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n"
+
+// ErrNoH2 is returned by H2Transport dials when the server does not negotiate HTTP/2.
+var ErrNoH2 = errors.New("volley: server did not negotiate h2")
+
+// H2Transport is an http.RoundTripper that implements the HTTP/2 "single-packet attack".
+// All requests to a host share one connection. The final frame of every request
+// (the one carrying END_STREAM) is withheld until Fire(), which writes all of them
+// in one write per connection.
+//
+// It relies on net/http speaking HTTP/2 over the conn returned by DialTLSContext,
+// which requires a Go release whose net/http accepts a non-*tls.Conn exposing ConnectionState.
+type H2Transport struct {
+	// Embedding http.Transport allows users to configure TLS, timeouts, etc.
+	*http.Transport
+
+	// HandshakeTimeout bounds the connect, and separately the TLS handshake, of each dial (default 10s).
+	// Zero means no extra timeout beyond the dial context.
+	HandshakeTimeout time.Duration
+
+	// Dialer is used to establish the underlying TCP connections.
+	// If nil, a zero-value net.Dialer is used.
+	Dialer *net.Dialer
+
+	// TCPNoDelay sets TCP_NODELAY on the connections (default true), so the
+	// withheld frames leave in one segment as soon as Fire writes them.
+	TCPNoDelay bool
+
+	// --- Atomic Counters ---
+
+	// heldCount tracks the number of streams whose final frame is withheld.
+	heldCount int32
+	// fired indicates whether the "Fire" signal has been triggered (0: Holding, 1: Fired).
+	fired int32
+
+	// waiters wakes up Wait when heldCount changes.
+	waiters notifier
+
+	// conns lists the live connections, so Fire can flush each of them.
+	conns   []*H2Conn
+	connsMu sync.Mutex
+
+	// releaseErrs collects the errors of failed writes of withheld frames.
+	releaseErrs []error
+	errMu       sync.Mutex
+}
+
+// NewH2Transport creates a new H2Transport ready for single-packet testing.
+func NewH2Transport() *H2Transport {
+	t := &H2Transport{
+		HandshakeTimeout: 10 * time.Second,
+		TCPNoDelay:       true,
+	}
+
+	t.Transport = &http.Transport{
+		ForceAttemptHTTP2: true,
+		MaxConnsPerHost:   1, // Critical: every request to a host shares one connection
+
+		// Certificates are verified by default, like Transport
+		TLSClientConfig: &tls.Config{
+			NextProtos: []string{"h2"},
+		},
+
+		DialTLSContext: t.dialTLS,
+	}
+	return t
+}
+
+// dialTLS connects to addr and sets up the TLS client. As in Transport, the handshake is
+// left to net/http (see H2Conn.HandshakeContext), so httptrace reports its real timing.
+func (t *H2Transport) dialTLS(ctx context.Context, network, addr string) (net.Conn, error) {
+	connectCtx, cancel := withTimeout(ctx, t.HandshakeTimeout)
+	defer cancel()
+
+	d := t.Dialer
+	if d == nil {
+		d = &net.Dialer{}
+	}
+	rawConn, err := d.DialContext(connectCtx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+	setNoDelay(rawConn, t.TCPNoDelay)
+
+	tlsConfig := t.Transport.TLSClientConfig.Clone()
+	if tlsConfig.ServerName == "" {
+		tlsConfig.ServerName = serverName(addr)
+	}
+
+	c := &H2Conn{
+		Conn:        tls.Client(rawConn, tlsConfig),
+		owner:       t,
+		prefaceLeft: len(h2ClientPreface),
+		heldStreams: make(map[uint32]bool),
+	}
+	t.connsMu.Lock()
+	t.conns = append(t.conns, c)
+	t.connsMu.Unlock()
+	return c, nil
+}
+
+// Fire writes the withheld final frames of every connection, one write per connection.
+// It also sets the transport to "Fired" mode, where subsequent frames pass through immediately.
+// The errors of the failed writes are reported by ReleaseErrors.
+func (t *H2Transport) Fire() {
+	if !atomic.CompareAndSwapInt32(&t.fired, 0, 1) {
+		return
+	}
+
+	t.connsMu.Lock()
+	conns := make([]*H2Conn, len(t.conns))
+	copy(conns, t.conns)
+	t.connsMu.Unlock()
+
+	for _, c := range conns {
+		c.mu.Lock()
+		if _, err := c.flushLocked(); err != nil {
+			t.releaseFailed(err)
+		}
+		c.mu.Unlock()
+	}
+}
+
+// releaseFailed records the error of a failed write of withheld frames.
+func (t *H2Transport) releaseFailed(err error) {
+	t.errMu.Lock()
+	t.releaseErrs = append(t.releaseErrs, err)
+	t.errMu.Unlock()
+}
+
+// ReleaseErrors returns the errors of the writes of withheld frames that failed on fire,
+// i.e. connections whose final frames never reached the server. Cleared by Reset().
+func (t *H2Transport) ReleaseErrors() []error {
+	t.errMu.Lock()
+	defer t.errMu.Unlock()
+	return append([]error(nil), t.releaseErrs...)
+}
+
+// Reset clears the held/fired state so the warm connections can be reused for a new batch.
+// NOTE: This must be called serially, after Fire (not concurrently with Fire or Wait).
+func (t *H2Transport) Reset() {
+	atomic.StoreInt32(&t.heldCount, 0)
+	atomic.StoreInt32(&t.fired, 0)
+
+	t.errMu.Lock()
+	t.releaseErrs = nil
+	t.errMu.Unlock()
+}
+
+// Wait blocks until at least want streams have their final frame withheld.
+func (t *H2Transport) Wait(ctx context.Context, want int) error {
+	if want <= 0 {
+		return nil
+	}
+
+	wake := t.waiters.subscribe()
+	defer t.waiters.unsubscribe(wake)
+
+	for {
+		if atomic.LoadInt32(&t.heldCount) >= int32(want) {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%w: timeout. want=%d, held=%d",
+				ctx.Err(), want, atomic.LoadInt32(&t.heldCount))
+		case <-wake:
+			t.waiters.ack()
+		}
+	}
+}
+
+// --- H2 Conn ---
+
+// H2Conn parses the outgoing HTTP/2 frame stream and withholds the final frame of each request.
+//
+// Frames are withheld, never split, and the withheld frames keep their relative order.
+// Besides final frames, two kinds of frames are queued behind them:
+//   - any frame of a stream that already has a withheld frame (e.g. CONTINUATION, RST_STREAM);
+//   - any HEADERS frame once a header block is withheld, because HPACK requires
+//     header blocks to reach the server in the order they were encoded.
+//
+// Everything else (SETTINGS, PING, WINDOW_UPDATE, request bodies) passes through immediately.
+type H2Conn struct {
+	net.Conn
+	owner *H2Transport
+
+	// prefaceLeft counts the client preface bytes still to pass through.
+	prefaceLeft int
+	// pending buffers an incomplete frame until the rest of it is written.
+	pending []byte
+	// queue holds the withheld frames, in write order.
+	queue []byte
+	// heldStreams marks the streams with at least one frame in queue.
+	heldStreams map[uint32]bool
+	// headersHeld reports whether queue contains a header block.
+	headersHeld bool
+	// heldFinal counts the final frames in queue, i.e. this connection's share of heldCount.
+	heldFinal int32
+
+	// mu serializes the frame writer against Fire.
+	mu sync.Mutex
+}
+
+func (c *H2Conn) Write(b []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var out []byte
+
+	// Fire may have landed while the queue of this conn was not flushed yet:
+	// withheld frames must go out before anything written after them.
+	flushed := false
+	if atomic.LoadInt32(&c.owner.fired) == 1 && len(c.queue) > 0 {
+		out = append(out, c.queue...)
+		c.resetQueue()
+		flushed = true
+	}
+
+	data := b
+	if c.prefaceLeft > 0 {
+		n := c.prefaceLeft
+		if n > len(data) {
+			n = len(data)
+		}
+		out = append(out, data[:n]...)
+		data = data[n:]
+		c.prefaceLeft -= n
+	}
+
+	c.pending = append(c.pending, data...)
+	for len(c.pending) >= h2FrameHeaderLen {
+		length := int(c.pending[0])<<16 | int(c.pending[1])<<8 | int(c.pending[2])
+		if len(c.pending) < h2FrameHeaderLen+length {
+			break
+		}
+		frame := c.pending[:h2FrameHeaderLen+length]
+		if c.hold(frame) {
+			c.queue = append(c.queue, frame...)
+		} else {
+			out = append(out, frame...)
+		}
+		c.pending = c.pending[h2FrameHeaderLen+length:]
+	}
+	// Detach the leftover from the consumed frames
+	c.pending = append([]byte(nil), c.pending...)
+
+	if len(out) > 0 {
+		if _, err := c.Conn.Write(out); err != nil {
+			if flushed {
+				c.owner.releaseFailed(err)
+			}
+			return 0, err
+		}
+	}
+	return len(b), nil
+}
+
+// hold decides whether a complete frame must be withheld. Caller must hold c.mu.
+func (c *H2Conn) hold(frame []byte) bool {
+	if atomic.LoadInt32(&c.owner.fired) == 1 {
+		return false
+	}
+
+	typ := frame[3]
+	flags := frame[4]
+	id := binary.BigEndian.Uint32(frame[5:h2FrameHeaderLen]) & 0x7fffffff
+	if id == 0 {
+		// Connection-level frame
+		return false
+	}
+
+	endStream := (typ == h2FrameHeaders || typ == h2FrameData) && flags&h2FlagEndStream != 0
+	held := c.heldStreams[id] ||
+		endStream ||
+		(c.headersHeld && (typ == h2FrameHeaders || typ == h2FrameContinuation))
+	if !held {
+		return false
+	}
+
+	c.heldStreams[id] = true
+	if typ == h2FrameHeaders {
+		c.headersHeld = true
+	}
+	if endStream {
+		c.heldFinal++
+		atomic.AddInt32(&c.owner.heldCount, 1)
+		c.owner.waiters.broadcast()
+	}
+	return true
+}
+
+// flushLocked writes the withheld frames as a single write. Caller must hold c.mu.
+func (c *H2Conn) flushLocked() (int, error) {
+	if len(c.queue) == 0 {
+		return 0, nil
+	}
+	queue := c.queue
+	c.resetQueue()
+	return c.Conn.Write(queue)
+}
+
+func (c *H2Conn) resetQueue() {
+	c.queue = nil
+	c.heldFinal = 0
+	c.heldStreams = make(map[uint32]bool)
+	c.headersHeld = false
+}
+
+// HandshakeContext runs the TLS handshake, bounded by the transport's HandshakeTimeout,
+// and fails with ErrNoH2 if the server did not negotiate HTTP/2.
+// net/http calls it right after the dial, between the httptrace TLS hooks.
+func (c *H2Conn) HandshakeContext(ctx context.Context) error {
+	tlsConn := c.Conn.(*tls.Conn)
+
+	ctx, cancel := withTimeout(ctx, c.owner.HandshakeTimeout)
+	defer cancel()
+
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		return err
+	}
+	if p := tlsConn.ConnectionState().NegotiatedProtocol; p != "h2" {
+		return fmt.Errorf("%w (negotiated %q)", ErrNoH2, p)
+	}
+	return nil
+}
+
+// ConnectionState returns the TLS state of the underlying connection.
+// net/http uses it to learn that HTTP/2 was negotiated.
+func (c *H2Conn) ConnectionState() tls.ConnectionState {
+	return c.Conn.(*tls.Conn).ConnectionState()
+}
+
+func (c *H2Conn) Close() error {
+	t := c.owner
+	t.connsMu.Lock()
+	for i, cc := range t.conns {
+		if cc == c {
+			t.conns = append(t.conns[:i], t.conns[i+1:]...)
+			break
+		}
+	}
+	t.connsMu.Unlock()
+
+	// The streams withheld on this connection are dropped: unless the transport already
+	// fired, they no longer count as held
+	c.mu.Lock()
+	if held := c.heldFinal; held > 0 && atomic.LoadInt32(&t.fired) == 0 {
+		c.resetQueue()
+		atomic.AddInt32(&t.heldCount, -held)
+		t.waiters.broadcast()
+	}
+	c.mu.Unlock()
+
+	return c.Conn.Close()
+}
@@ -0,0 +1,214 @@
+package volley
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httptrace"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newH2Server starts a TLS server speaking HTTP/2 that answers with the protocol of the request.
+func newH2Server(t *testing.T, h http.HandlerFunc) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewUnstartedServer(h)
+	srv.EnableHTTP2 = true
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// newTestH2Transport returns an H2Transport trusting the certificate of srv.
+func newTestH2Transport(srv *httptest.Server) *H2Transport {
+	ht := NewH2Transport()
+	ht.TLSClientConfig.RootCAs = srv.Client().Transport.(*http.Transport).TLSClientConfig.RootCAs
+	return ht
+}
+
+func TestH2SinglePacket(t *testing.T) {
+	var mu sync.Mutex
+	var arrivals []time.Time
+	srv := newH2Server(t, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		arrivals = append(arrivals, time.Now())
+		mu.Unlock()
+		io.WriteString(w, r.Proto)
+	})
+	ht := newTestH2Transport(srv)
+	defer ht.CloseIdleConnections()
+	client := &http.Client{Transport: ht}
+
+	const n = 10
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := client.Get(srv.URL)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer resp.Body.Close()
+			if b, _ := io.ReadAll(resp.Body); string(b) != "HTTP/2.0" {
+				t.Errorf("response %q, want HTTP/2.0", b)
+			}
+		}()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := ht.Wait(ctx, n); err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	early := len(arrivals)
+	mu.Unlock()
+	if early != 0 {
+		t.Fatalf("%d requests processed before Fire", early)
+	}
+
+	ht.Fire()
+	wg.Wait()
+	if len(arrivals) != n {
+		t.Fatalf("%d requests processed, want %d", len(arrivals), n)
+	}
+	if errs := ht.ReleaseErrors(); len(errs) != 0 {
+		t.Fatalf("ReleaseErrors = %v, want none", errs)
+	}
+}
+
+var errWriteFailed = errors.New("write failed")
+
+// breakableConn accepts every write until it is broken, then fails them.
+type breakableConn struct {
+	net.Conn
+	broken atomic.Bool
+}
+
+func (c *breakableConn) Write(b []byte) (int, error) {
+	if c.broken.Load() {
+		return 0, errWriteFailed
+	}
+	return len(b), nil
+}
+
+func (c *breakableConn) Close() error { return nil }
+
+// h2HoldOne registers an H2Conn over conn with ht, as a dial would, and writes the client
+// preface and a request made of one HEADERS frame, which it withholds.
+func h2HoldOne(t *testing.T, ht *H2Transport, conn net.Conn) *H2Conn {
+	t.Helper()
+	c := &H2Conn{
+		Conn:        conn,
+		owner:       ht,
+		prefaceLeft: len(h2ClientPreface),
+		heldStreams: make(map[uint32]bool),
+	}
+	ht.connsMu.Lock()
+	ht.conns = append(ht.conns, c)
+	ht.connsMu.Unlock()
+
+	// HEADERS, END_STREAM|END_HEADERS, stream 1, one byte of header block
+	frame := []byte{0, 0, 1, h2FrameHeaders, h2FlagEndStream | 0x4, 0, 0, 0, 1, 0x82}
+	if _, err := c.Write(append([]byte(h2ClientPreface), frame...)); err != nil {
+		t.Fatal(err)
+	}
+	if held := atomic.LoadInt32(&ht.heldCount); held != 1 {
+		t.Fatalf("heldCount = %d, want 1", held)
+	}
+	return c
+}
+
+func TestH2FireReportsFlushError(t *testing.T) {
+	ht := NewH2Transport()
+	conn := &breakableConn{}
+	h2HoldOne(t, ht, conn)
+
+	// The final frame can no longer be written
+	conn.broken.Store(true)
+	ht.Fire()
+	if errs := ht.ReleaseErrors(); len(errs) != 1 || !errors.Is(errs[0], errWriteFailed) {
+		t.Fatalf("ReleaseErrors = %v, want the failed flush", errs)
+	}
+
+	ht.Reset()
+	if errs := ht.ReleaseErrors(); len(errs) != 0 {
+		t.Fatalf("ReleaseErrors after Reset = %v, want none", errs)
+	}
+}
+
+func TestH2CloseDropsHeldStreams(t *testing.T) {
+	ht := NewH2Transport()
+	c := h2HoldOne(t, ht, &breakableConn{})
+
+	c.Close()
+	if held := atomic.LoadInt32(&ht.heldCount); held != 0 {
+		t.Fatalf("heldCount after Close = %d, want 0", held)
+	}
+	ht.Fire()
+	if errs := ht.ReleaseErrors(); len(errs) != 0 {
+		t.Fatalf("ReleaseErrors = %v: Fire wrote to a closed connection", errs)
+	}
+}
+
+func TestH2HandshakeTrace(t *testing.T) {
+	h2 := newH2Server(t, func(w http.ResponseWriter, r *http.Request) {})
+	h1 := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer h1.Close()
+
+	cases := []struct {
+		name  string
+		srv   *httptest.Server
+		proto string
+		err   error
+	}{
+		{"h2", h2, "h2", nil},
+		{"no h2", h1, "", ErrNoH2},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ht := newTestH2Transport(c.srv)
+			defer ht.CloseIdleConnections()
+			// Fired: the request goes straight through
+			ht.Fire()
+
+			var starts, dones int
+			var state tls.ConnectionState
+			var hsErr error
+			trace := &httptrace.ClientTrace{
+				TLSHandshakeStart: func() { starts++ },
+				TLSHandshakeDone: func(cs tls.ConnectionState, err error) {
+					state, hsErr = cs, err
+					dones++
+				},
+			}
+			req, err := http.NewRequestWithContext(httptrace.WithClientTrace(context.Background(), trace), "GET", c.srv.URL, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp, err := ht.RoundTrip(req)
+			if err == nil {
+				resp.Body.Close()
+			}
+
+			// The hooks bracket the handshake of H2Conn, once each
+			if starts != 1 || dones != 1 {
+				t.Fatalf("TLSHandshakeStart x%d, TLSHandshakeDone x%d, want 1 each", starts, dones)
+			}
+			if !errors.Is(hsErr, c.err) || state.NegotiatedProtocol != c.proto {
+				t.Fatalf("TLSHandshakeDone(%q, %v), want (%q, %v)", state.NegotiatedProtocol, hsErr, c.proto, c.err)
+			}
+			if !errors.Is(err, c.err) {
+				t.Fatalf("RoundTrip err = %v, want %v", err, c.err)
+			}
+		})
+	}
+}
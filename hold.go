@@ -0,0 +1,74 @@
+package volley
+
+// holder is the hold/release state of a connection, independent of any Transport: it
+// splits the bytes written through it at the straddle point and keeps the tail until
+// the release. StraddleConn embeds one, configured by the Transport that dialed it (see
+// Transport.newHolder) or, for a standalone connection, by the options of NewStraddleConn.
+// It is not safe for concurrent use: StraddleConn guards it with its mu.
+type holder struct {
+	// held contains the bytes withheld so far (see splitIndex).
+	held  []byte
+	holdN int
+	// scratch is reused by hold to assemble held + new bytes, until the connection is released.
+	scratch []byte
+	// point is the straddle point; pointFound marks that the header block was complete.
+	point      StraddlePoint
+	pointFound bool
+	// strategy, if set, replaces point and holdN (see Transport.HoldStrategy).
+	// sent counts the bytes of the current request written to the conn, for it.
+	strategy HoldStrategy
+	sent     int
+	// released marks a connection that has already flushed its held data.
+	// From then on it behaves as a plain connection.
+	released bool
+}
+
+// newHolder returns the hold state of a new connection, as configured by HoldBytes,
+// StraddlePoint and HoldStrategy.
+func (t *Transport) newHolder() holder {
+	holdN := t.HoldBytes
+	if holdN < 0 {
+		holdN = 1
+	}
+	return holder{
+		holdN:    holdN,
+		point:    t.StraddlePoint,
+		strategy: t.HoldStrategy,
+	}
+}
+
+// holds reports whether the connection holds bytes at all (see Transport.HoldBytes).
+func (h *holder) holds() bool {
+	return h.holdN > 0 || h.strategy != nil
+}
+
+// hold adds b to the bytes of the request and returns the ones to send now, the rest
+// staying held. prev is the number of bytes at the start of send that were held by
+// earlier writes (see writtenOf). send is only valid until the next call.
+func (h *holder) hold(b []byte) (send []byte, prev int) {
+	if h.point != LastByte && h.pointFound {
+		// Everything after the straddle point stays held
+		h.held = append(h.held, b...)
+		return nil, 0
+	}
+
+	// Assemble held + b in the scratch buffer, reused across writes
+	prev = len(h.held)
+	payload := append(h.scratch[:0], h.held...)
+	payload = append(payload, b...)
+	h.scratch = payload
+
+	// Keep the bytes from the straddle point on (by default the last holdN bytes).
+	// held gets its own copy, since the scratch buffer is overwritten by the next write.
+	split := h.splitIndex(payload)
+	h.held = append(h.held[:0], payload[split:]...)
+	return payload[:split], prev
+}
+
+// take returns the held bytes and forgets them, along with the scratch buffer.
+func (h *holder) take() []byte {
+	held := h.held
+	h.held = nil
+	h.scratch = nil
+	return held
+}
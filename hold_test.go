@@ -0,0 +1,207 @@
+package volley
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// recConn is a net.Conn recording what is written to it.
+type recConn struct {
+	net.Conn
+	mu  sync.Mutex
+	buf []byte
+}
+
+func (r *recConn) Write(b []byte) (int, error) {
+	r.mu.Lock()
+	r.buf = append(r.buf, b...)
+	r.mu.Unlock()
+	return len(b), nil
+}
+
+func (r *recConn) RemoteAddr() net.Addr { return nil }
+func (r *recConn) Close() error         { return nil }
+
+func (r *recConn) got() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return string(r.buf)
+}
+
+// wrapRec wraps c for vt, as a dial of the current batch would.
+func wrapRec(vt *Transport, c net.Conn) *StraddleConn {
+	return vt.wrapConn(context.Background(), c, "volley.test:80", atomic.LoadUint32(&vt.gen),
+		vt.fireChAtom.Load().(chan struct{}), vt.abandonAtom.Load().(chan struct{}))
+}
+
+func TestHoldBytes(t *testing.T) {
+	cases := []struct {
+		name      string
+		holdBytes int
+		writes    []string
+		sent      string
+	}{
+		{"one", 1, []string{"hello-world"}, "hello-worl"},
+		{"four", 4, []string{"hello-world"}, "hello-w"},
+		{"four across writes", 4, []string{"a", "b", "c", "defgh", "i"}, "abcde"},
+		{"write smaller than HoldBytes", 8, []string{"hello"}, ""},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			vt := NewTransport(WithHoldBytes(c.holdBytes))
+			rc := &recConn{}
+			sc := wrapRec(vt, rc)
+			defer sc.Close()
+
+			for _, w := range c.writes {
+				if n, err := sc.Write([]byte(w)); n != len(w) || err != nil {
+					t.Fatalf("Write(%q) = %d, %v", w, n, err)
+				}
+			}
+			if got := rc.got(); got != c.sent {
+				t.Fatalf("sent before fire %q, want %q", got, c.sent)
+			}
+			if s := vt.Stats(); s.Held != 1 {
+				t.Fatalf("Held = %d, want 1", s.Held)
+			}
+
+			vt.Fire()
+			if got, want := rc.got(), strings.Join(c.writes, ""); got != want {
+				t.Fatalf("sent after fire %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestStraddlePointSplitHeaders(t *testing.T) {
+	get := []string{"GET / HTTP/1.1\r\n", "Host: volley.test\r\n\r", "\n"}
+	post := []string{"POST / HTTP/1.1\r\nHost: volley.test\r\n", "Content-Length: 4\r\n\r\nbody"}
+	cases := []struct {
+		name      string
+		point     StraddlePoint
+		holdBytes int
+		writes    []string
+		sent      string
+	}{
+		{"end of headers", EndOfHeaders, 1, get, "GET / HTTP/1.1\r\nHost: volley.test\r\n\r"},
+		{"end of headers, hold 4", EndOfHeaders, 4, get, "GET / HTTP/1.1\r\nHost: volley.test"},
+		{"first body byte", FirstBodyByte, 1, post, "POST / HTTP/1.1\r\nHost: volley.test\r\nContent-Length: 4\r\n\r\n"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			vt := NewTransport(WithStraddlePoint(c.point), WithHoldBytes(c.holdBytes))
+			rc := &recConn{}
+			sc := wrapRec(vt, rc)
+			defer sc.Close()
+
+			last := len(c.writes) - 1
+			for i, w := range c.writes {
+				if n, err := sc.Write([]byte(w)); n != len(w) || err != nil {
+					t.Fatalf("Write(%q) = %d, %v", w, n, err)
+				}
+				// Nothing goes out until the header block is complete
+				if got := rc.got(); i < last && got != "" {
+					t.Fatalf("sent %q after write %d of the header block, want nothing", got, i+1)
+				}
+			}
+			all := strings.Join(c.writes, "")
+			if got := rc.got(); got != c.sent {
+				t.Fatalf("sent before fire %q, want %q", got, c.sent)
+			}
+			sc.mu.Lock()
+			held := string(sc.held)
+			sc.mu.Unlock()
+			if held != all[len(c.sent):] {
+				t.Fatalf("held %q, want %q", held, all[len(c.sent):])
+			}
+
+			vt.Fire()
+			if got := rc.got(); got != all {
+				t.Fatalf("sent after fire %q, want %q", got, all)
+			}
+		})
+	}
+}
+
+// BenchmarkHoldChunks writes a 64 KB body in 64-byte chunks through a holder, reusing its
+// scratch buffer as Write does, and, for comparison, with a fresh payload per write.
+func BenchmarkHoldChunks(b *testing.B) {
+	chunk := []byte(strings.Repeat("x", 64))
+	const chunks = 1024
+
+	for _, bb := range []struct {
+		name  string
+		fresh bool
+	}{{"scratch", false}, {"fresh", true}} {
+		b.Run(bb.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				h := holder{holdN: 1}
+				for j := 0; j < chunks; j++ {
+					if bb.fresh {
+						h.scratch = nil
+					}
+					h.hold(chunk)
+				}
+			}
+		})
+	}
+}
+
+// shortConn is a net.Conn accepting limit bytes in all, failing the write that exceeds it,
+// with err (nil for a short count alone).
+type shortConn struct {
+	net.Conn
+	limit int
+	err   error
+	got   []byte
+}
+
+func (c *shortConn) Write(b []byte) (int, error) {
+	if n := c.limit - len(c.got); len(b) > n {
+		c.got = append(c.got, b[:n]...)
+		return n, c.err
+	}
+	c.got = append(c.got, b...)
+	return len(b), nil
+}
+
+func (c *shortConn) RemoteAddr() net.Addr { return nil }
+func (c *shortConn) Close() error         { return nil }
+
+func TestShortWrite(t *testing.T) {
+	errReset := errors.New("connection reset")
+	cases := []struct {
+		name  string
+		limit int
+		err   error
+		wantN int
+		want  error
+	}{
+		// "abcd" sent "a" and held "bcd": the next write sends "bcdefg"
+		{"held bytes cut", 3, errReset, 0, errReset},
+		{"new bytes cut", 6, errReset, 2, errReset},
+		{"short count", 6, nil, 2, io.ErrShortWrite},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			vt := NewTransport(WithHoldBytes(3))
+			sc := wrapRec(vt, &shortConn{limit: c.limit, err: c.err})
+			defer sc.Close()
+
+			if n, err := sc.Write([]byte("abcd")); n != 4 || err != nil {
+				t.Fatalf("first Write = %d, %v", n, err)
+			}
+			n, err := sc.Write([]byte("efghij"))
+			if n != c.wantN || !errors.Is(err, c.want) {
+				t.Fatalf("Write = %d, %v, want %d, %v", n, err, c.wantN, c.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,72 @@
+package volley
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Logger receives debug lines at the key transitions of a Transport:
+// dials, held connections, fires, closes and Wait checks.
+type Logger interface {
+	Debugf(format string, args ...any)
+}
+
+// attrLogger is implemented by Loggers taking the key/value pairs of a line as
+// structured attributes instead of a formatted line (see WithSlog).
+type attrLogger interface {
+	logAttrs(msg string, kv []any)
+}
+
+// logf logs msg and the key/value pairs kv through t.Logger, if set. Plain Loggers get
+// a "volley: msg key=value ..." line. It must not be called while holding a StraddleConn's mu.
+func (t *Transport) logf(msg string, kv ...any) {
+	if t.Logger == nil {
+		return
+	}
+	if al, ok := t.Logger.(attrLogger); ok {
+		al.logAttrs(msg, kv)
+		return
+	}
+
+	var b strings.Builder
+	b.WriteString("volley: ")
+	b.WriteString(msg)
+	for i := 0; i+1 < len(kv); i += 2 {
+		fmt.Fprintf(&b, " %v=%s", kv[i], logValue(kv[i+1]))
+	}
+	t.Logger.Debugf("%s", b.String())
+}
+
+// logValue formats v for a log line, quoting strings and errors that are empty or contain
+// spaces, quotes or '=' (as logfmt does).
+func logValue(v any) string {
+	var s string
+	switch vv := v.(type) {
+	case string:
+		s = vv
+	case error:
+		s = vv.Error()
+	case time.Time:
+		return vv.Format(time.RFC3339Nano)
+	default:
+		return fmt.Sprint(v)
+	}
+	if s == "" || strings.ContainsAny(s, " \t\"=") {
+		return fmt.Sprintf("%q", s)
+	}
+	return s
+}
+
+// connAlive reports a connection that was established or rejoined a batch.
+func (t *Transport) connAlive(sc *StraddleConn) {
+	t.emit(EventConnAlive, sc.Label, sc.Addr)
+	t.logf("conn alive", "addr", sc.Addr, "label", sc.Label)
+}
+
+// connHeld reports a connection that started holding data.
+func (t *Transport) connHeld(sc *StraddleConn) {
+	t.emit(EventConnHeld, sc.Label, sc.Addr)
+	t.logf("conn held", "addr", sc.Addr, "label", sc.Label, "held", atomic.LoadInt32(&t.heldCount))
+}
@@ -0,0 +1,79 @@
+package volley
+
+import (
+	"context"
+	"io"
+	"net"
+	"sync"
+	"testing"
+)
+
+// lockCheckLogger fails the test if a line is logged while sc.mu is held (see logf).
+type lockCheckLogger struct {
+	t *testing.T
+
+	mu    sync.Mutex
+	sc    *StraddleConn
+	lines []string
+}
+
+func (l *lockCheckLogger) Debugf(format string, args ...any) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.lines = append(l.lines, args[0].(string))
+	if l.sc == nil {
+		return
+	}
+	if !l.sc.mu.TryLock() {
+		l.t.Errorf("logged while holding the connection's mu: %s", args[0])
+		return
+	}
+	l.sc.mu.Unlock()
+}
+
+func (l *lockCheckLogger) logged(prefix string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, line := range l.lines {
+		if len(line) >= len(prefix) && line[:len(prefix)] == prefix {
+			return true
+		}
+	}
+	return false
+}
+
+func TestReadinessProbeLogsOutsideConnLock(t *testing.T) {
+	l := &lockCheckLogger{t: t}
+	vt := NewTransport(WithReadinessProbe(true), WithLogger(l))
+
+	client, server := net.Pipe()
+	defer server.Close()
+	go io.Copy(io.Discard, server)
+	sc := vt.wrapConn(context.Background(), client, "volley.test:80", 0,
+		vt.fireChAtom.Load().(chan struct{}), vt.abandonAtom.Load().(chan struct{}))
+	defer sc.Close()
+	l.mu.Lock()
+	l.sc = sc
+	l.mu.Unlock()
+
+	if _, err := sc.Write([]byte("GET / HTTP/1.1\r\nHost: volley.test\r\n\r\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	// The server answers the held request: "conn unhealthy"
+	go server.Write([]byte("HTTP/1.1 400 Bad Request\r\n\r\n"))
+	if _, err := sc.Read(make([]byte, 64)); err != nil {
+		t.Fatal(err)
+	}
+	if !l.logged("volley: conn unhealthy") {
+		t.Fatal("unhealthy connection not logged")
+	}
+
+	// The fire drops it: "conn skipped"
+	if n, _ := vt.Fire(); n != 0 {
+		t.Fatalf("Fire released %d connections, want 0", n)
+	}
+	if !l.logged("volley: conn skipped") {
+		t.Fatal("skipped connection not logged")
+	}
+}
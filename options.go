@@ -0,0 +1,234 @@
+package volley
+
+import (
+	"context"
+	"crypto/tls"
+	"math"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Option configures a Transport created by NewTransportWithOptions (or NewTransport).
+// Options are applied in order, so later options override earlier ones.
+type Option func(*Transport)
+
+// WithTLSConfig replaces the TLS configuration (a clone of cfg is used).
+// Options that tweak TLS, such as WithInsecureSkipVerify, must come after it.
+// A nil cfg keeps the current configuration.
+func WithTLSConfig(cfg *tls.Config) Option {
+	return func(t *Transport) {
+		if cfg == nil {
+			return
+		}
+		t.TLSClientConfig = cfg.Clone()
+	}
+}
+
+// WithTLSHandshaker sets the function setting up the TLS client of each https connection
+// in place of tls.Client (see Transport.TLSHandshaker).
+func WithTLSHandshaker(h func(rawConn net.Conn, serverName string) (net.Conn, error)) Option {
+	return func(t *Transport) {
+		t.TLSHandshaker = h
+	}
+}
+
+// WithProxy sends every connection through the proxy at rawURL (see Transport.Proxy):
+// http://, https://, socks5:// or socks5h://, with optional user:password credentials.
+// An empty rawURL connects directly. A malformed rawURL fails every dial with the parse error.
+func WithProxy(rawURL string) Option {
+	return func(t *Transport) {
+		if rawURL == "" {
+			t.Proxy = nil
+			return
+		}
+		u, err := url.Parse(rawURL)
+		t.Proxy = func(*http.Request) (*url.URL, error) {
+			return u, err
+		}
+	}
+}
+
+// WithHandshakeTimeout bounds the connect, and separately the TLS handshake, of each tracked dial (default 10s).
+// Zero disables the extra timeout, leaving only the request context's deadline.
+func WithHandshakeTimeout(d time.Duration) Option {
+	return func(t *Transport) {
+		t.HandshakeTimeout = d
+	}
+}
+
+// WithInsecureSkipVerify disables TLS certificate verification when skip is true.
+// Certificates are verified by default; skipping is meant for test targets with
+// self-signed certificates.
+func WithInsecureSkipVerify(skip bool) Option {
+	return func(t *Transport) {
+		t.TLSClientConfig.InsecureSkipVerify = skip
+	}
+}
+
+// WithHoldBytes sets the number of trailing bytes withheld until Fire() (default 1).
+// Zero disables holding (see Transport.HoldBytes).
+func WithHoldBytes(n int) Option {
+	return func(t *Transport) {
+		t.HoldBytes = n
+	}
+}
+
+// WithStraddlePoint sets where requests are split between sent and held bytes (default LastByte).
+func WithStraddlePoint(p StraddlePoint) Option {
+	return func(t *Transport) {
+		t.StraddlePoint = p
+	}
+}
+
+// WithReadinessProbe makes the fires skip the held connections the server answered or closed
+// before the fire (see Transport.ReadinessProbe).
+func WithReadinessProbe(enabled bool) Option {
+	return func(t *Transport) {
+		t.ReadinessProbe = enabled
+	}
+}
+
+// WithFireOrder sets how Fire() releases the held connections (default OrderBroadcast).
+func WithFireOrder(order FireOrder) Option {
+	return func(t *Transport) {
+		t.FireOrder = order
+	}
+}
+
+// WithSpinFire makes Fire() release the held connections lead after the call, with the
+// releasing goroutines busy-spinning until then (see Transport.SpinLead).
+func WithSpinFire(lead time.Duration) Option {
+	return func(t *Transport) {
+		t.SpinLead = lead
+	}
+}
+
+// WithHoldStrategy sets the HoldStrategy splitting every request (see Transport.HoldStrategy).
+func WithHoldStrategy(s HoldStrategy) Option {
+	return func(t *Transport) {
+		t.HoldStrategy = s
+	}
+}
+
+// WithEvents sets the capacity of the Events() channel and whether a full channel blocks
+// the emitter (block true) or drops the event (see Transport.EventBuffer).
+func WithEvents(buffer int, block bool) Option {
+	return func(t *Transport) {
+		t.EventBuffer = buffer
+		t.EventsBlock = block
+	}
+}
+
+// WithLogger sets the Logger receiving debug lines (default none).
+func WithLogger(l Logger) Option {
+	return func(t *Transport) {
+		t.Logger = l
+	}
+}
+
+// WithHTTP2 allows the server to negotiate HTTP/2 via ALPN (default false).
+//
+// Straddling is designed for HTTP/1.1: over HTTP/2 the held bytes are the tail
+// of the connection's frame stream, not of an individual request.
+func WithHTTP2(enabled bool) Option {
+	return func(t *Transport) {
+		t.ForceAttemptHTTP2 = enabled
+		if enabled {
+			t.TLSClientConfig.NextProtos = []string{"h2", "http/1.1"}
+		} else {
+			t.TLSClientConfig.NextProtos = []string{"http/1.1"}
+		}
+	}
+}
+
+// WithKeepAlive enables keep-alives, so a connection can carry several requests over
+// successive batches (default false: one request per connection).
+//
+// net/http sends one request at a time on a connection, and the next one only after the
+// previous response was read, so a connection never holds more than one request: a batch
+// needs one connection per request. What keep-alive saves is the dial. After Fire the
+// connection passes bytes through; once Reset() starts a new batch, the first Write of a
+// request on it arms the connection again, counting as a new connection of the batch
+// (see Stats.DialStarted and Stats.Alive). Bytes always reach the server in write order.
+func WithKeepAlive(enabled bool) Option {
+	return func(t *Transport) {
+		t.DisableKeepAlives = !enabled
+		if enabled {
+			// Keep every connection of a batch for the next one
+			t.MaxIdleConnsPerHost = math.MaxInt32
+		} else {
+			t.MaxIdleConnsPerHost = -1
+		}
+	}
+}
+
+// WithDialer sets the net.Dialer used to establish the underlying TCP connections
+// (see Transport.Dialer).
+func WithDialer(d *net.Dialer) Option {
+	return func(t *Transport) {
+		t.Dialer = d
+	}
+}
+
+// WithLocalAddrFunc sets the function choosing the local address of each dial
+// (see Transport.LocalAddrFunc).
+func WithLocalAddrFunc(f func(attempt int) net.Addr) Option {
+	return func(t *Transport) {
+		t.LocalAddrFunc = f
+	}
+}
+
+// WithDNS sets how dials resolve the target host (see Transport.DNS).
+func WithDNS(policy DNSPolicy) Option {
+	return func(t *Transport) {
+		t.DNS = policy
+	}
+}
+
+// WithDialRetry makes each tracked dial try up to attempts times, waiting backoff before
+// the first retry and twice as long before each next one (see Transport.DialAttempts).
+func WithDialRetry(attempts int, backoff time.Duration) Option {
+	return func(t *Transport) {
+		t.DialAttempts = attempts
+		t.DialBackoff = backoff
+	}
+}
+
+// WithMaxConcurrentDials caps the number of connects running at once (see Transport.MaxConcurrentDials).
+func WithMaxConcurrentDials(n int) Option {
+	return func(t *Transport) {
+		t.MaxConcurrentDials = n
+	}
+}
+
+// WithNoDelay sets TCP_NODELAY on every dialed TCP connection (default true, see Transport.TCPNoDelay).
+func WithNoDelay(noDelay bool) Option {
+	return func(t *Transport) {
+		t.TCPNoDelay = noDelay
+	}
+}
+
+// WithTCPCork sets TCP_CORK on the TCP connections while they hold a request, on Linux
+// (see Transport.TCPCork).
+func WithTCPCork(enabled bool) Option {
+	return func(t *Transport) {
+		t.TCPCork = enabled
+	}
+}
+
+// WithUnixSocket dials every connection to the UNIX domain socket at path (see Transport.UnixSocket).
+func WithUnixSocket(path string) Option {
+	return func(t *Transport) {
+		t.UnixSocket = path
+	}
+}
+
+// WithBaseDialer sets the function used to establish the underlying connections (see Transport.BaseDialer).
+// It takes precedence over WithDialer.
+func WithBaseDialer(dial func(ctx context.Context, network, addr string) (net.Conn, error)) Option {
+	return func(t *Transport) {
+		t.BaseDialer = dial
+	}
+}
@@ -0,0 +1,43 @@
+package volley
+
+import (
+	"crypto/tls"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWithTLSConfigNil(t *testing.T) {
+	// Must not leave a nil configuration for the options after it, nor for https dials
+	vt := NewTransport(WithTLSConfig(nil), WithInsecureSkipVerify(true))
+
+	cfg := vt.TLSClientConfig
+	if cfg == nil {
+		t.Fatal("TLSClientConfig is nil")
+	}
+	if !cfg.InsecureSkipVerify {
+		t.Error("WithInsecureSkipVerify after WithTLSConfig(nil) was not applied")
+	}
+	if len(cfg.NextProtos) != 1 || cfg.NextProtos[0] != "http/1.1" {
+		t.Errorf("NextProtos = %q, want the default [http/1.1]", cfg.NextProtos)
+	}
+}
+
+func TestBadCertRejectedByDefault(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	vt := NewTransport(WithHoldBytes(0))
+	defer vt.Close()
+	var certErr *tls.CertificateVerificationError
+	if err := recvErr(t, get(vt, srv.URL)); !errors.As(err, &certErr) {
+		t.Fatalf("err = %v, want a certificate verification error", err)
+	}
+
+	insecure := NewTransport(WithHoldBytes(0), WithInsecureSkipVerify(true))
+	defer insecure.Close()
+	if err := recvErr(t, get(insecure, srv.URL)); err != nil {
+		t.Fatalf("with WithInsecureSkipVerify(true): %v", err)
+	}
+}
@@ -0,0 +1,33 @@
+package volley
+
+import "time"
+
+// FireOrder selects how Fire() releases the held connections of a batch.
+type FireOrder int
+
+const (
+	// OrderBroadcast releases every connection at once, spread over one goroutine
+	// per CPU (the default). It gives the tightest spread, in no particular order.
+	OrderBroadcast FireOrder = iota
+	// OrderFIFO releases the connections one after another, in the order they were armed.
+	OrderFIFO
+	// OrderLIFO releases the connections one after another, the last armed first.
+	OrderLIFO
+)
+
+// releaseOrdered releases the connections of batch sequentially, following order,
+// and returns how many were released. batch is in arming order.
+func (t *Transport) releaseOrdered(batch []*StraddleConn, order FireOrder, trigger time.Time) int {
+	t.spinUntil(trigger)
+	released := 0
+	for i := range batch {
+		sc := batch[i]
+		if order == OrderLIFO {
+			sc = batch[len(batch)-1-i]
+		}
+		if sc.release(trigger) {
+			released++
+		}
+	}
+	return released
+}
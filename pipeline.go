@@ -0,0 +1,172 @@
+package volley
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync/atomic"
+)
+
+// ErrPipelineTarget is returned by Pipeline when its requests do not all go to the same
+// scheme and host:port.
+var ErrPipelineTarget = errors.New("volley: pipelined requests must share scheme and host")
+
+// Pipeline sends reqs pipelined on a single connection (HTTP/1.1 pipelining): every request
+// but the last is written in full, and the last one is straddled like a request of
+// RoundTrip, so the server reads the leading requests right away and the last one once
+// the batch fires. Call it alongside the other requests of the batch, then Wait and Fire;
+// the connection counts as one (see Stats), whatever the number of requests it carries.
+// It returns the results in the order of reqs, the responses being read in that order.
+//
+// Pipelining is off by default: RoundTrip, and so http.Client, sends one request per
+// connection (or one at a time with WithKeepAlive), as net/http never pipelines. Pipeline
+// is the only way to put several requests in flight on one connection, and it bypasses
+// net/http: no redirects, no cookies, no timing records (Result.Timing is left zero).
+// Servers answer pipelined requests in order, and many close the connection after the
+// first response or refuse pipelining altogether; the requests left unanswered then fail.
+//
+// All requests must go to the same scheme (http or https) and host:port, otherwise
+// Pipeline returns ErrPipelineTarget. ctx bounds the dial and the whole exchange: when it
+// is done, the connection is closed. The returned error reports a failure to set up the
+// connection; the failures of the requests themselves are in their results.
+func (t *Transport) Pipeline(ctx context.Context, reqs []*http.Request) ([]*Result, error) {
+	if len(reqs) == 0 {
+		return nil, nil
+	}
+	if atomic.LoadInt32(&t.closed) == 1 {
+		return nil, ErrTransportClosed
+	}
+
+	scheme, addr := reqs[0].URL.Scheme, canonicalAddr(reqs[0].URL)
+	for _, req := range reqs[1:] {
+		if req.URL.Scheme != scheme || canonicalAddr(req.URL) != addr {
+			return nil, ErrPipelineTarget
+		}
+	}
+
+	conn, err := t.dialPipeline(ctx, scheme, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	// Close the connection when ctx is done, unblocking the reads and writes
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	// Write from another goroutine, as the server may answer the leading requests before
+	// the last one is written
+	var werr error
+	wrote := make(chan struct{})
+	go func() {
+		defer close(wrote)
+		if werr = writePipeline(conn, reqs); werr != nil {
+			conn.Close()
+		}
+	}()
+
+	results := make([]*Result, len(reqs))
+	for i, req := range reqs {
+		results[i] = &Result{Request: req}
+	}
+
+	// The leading responses arrive while the last request is held, which is no sign of
+	// an unhealthy connection (see Transport.ReadinessProbe): read past the StraddleConn
+	var r io.Reader = conn
+	if sc, ok := conn.(*StraddleConn); ok {
+		r = sc.Underlying()
+	}
+	br := bufio.NewReader(r)
+	for i, req := range reqs {
+		res := results[i]
+		resp, err := http.ReadResponse(br, req)
+		if err == nil {
+			res.Response = resp
+			res.Body, err = io.ReadAll(resp.Body)
+			resp.Body.Close()
+		}
+		if err != nil {
+			// The connection is unusable: report the cause on the unanswered requests.
+			// A failed write closed it, unless the read failed first.
+			select {
+			case <-wrote:
+				if werr != nil {
+					err = werr
+				}
+			default:
+			}
+			if ctx.Err() != nil {
+				err = ctx.Err()
+			}
+			for _, res := range results[i:] {
+				if res.Err == nil {
+					res.Err = err
+				}
+			}
+			break
+		}
+	}
+
+	conn.Close()
+	<-wrote
+	return results, nil
+}
+
+// dialPipeline opens the connection of a pipeline through the tracked dials of RoundTrip,
+// completing the TLS handshake for https.
+func (t *Transport) dialPipeline(ctx context.Context, scheme, addr string) (net.Conn, error) {
+	switch scheme {
+	case "http":
+		return t.Transport.DialContext(ctx, "tcp", addr)
+	case "https":
+	default:
+		return nil, fmt.Errorf("volley: pipeline: unsupported scheme %q", scheme)
+	}
+
+	c, err := t.Transport.DialTLSContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if hs, ok := c.(interface{ HandshakeContext(context.Context) error }); ok {
+		if err := hs.HandshakeContext(ctx); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// writePipeline writes reqs to conn: the leading requests as one unheld write, then the last
+// request, which a StraddleConn holds as usual.
+func writePipeline(conn net.Conn, reqs []*http.Request) error {
+	var lead bytes.Buffer
+	for _, req := range reqs[:len(reqs)-1] {
+		if err := req.Write(&lead); err != nil {
+			return err
+		}
+	}
+
+	if lead.Len() > 0 {
+		var err error
+		if sc, ok := conn.(*StraddleConn); ok {
+			_, err = sc.writeUnheld(lead.Bytes())
+		} else {
+			_, err = conn.Write(lead.Bytes())
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return reqs[len(reqs)-1].Write(conn)
+}
@@ -0,0 +1,99 @@
+package volley
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPipelineFire(t *testing.T) {
+	var mu sync.Mutex
+	var paths []string
+	served := func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), paths...)
+	}
+	vt := NewTestTransport(func(ctx context.Context) (net.Conn, error) {
+		client, server := net.Pipe()
+		go func() {
+			// Answers each request with its path, in order
+			defer server.Close()
+			br := bufio.NewReader(server)
+			for {
+				req, err := http.ReadRequest(br)
+				if err != nil {
+					return
+				}
+				req.Body.Close()
+				mu.Lock()
+				paths = append(paths, req.URL.Path)
+				mu.Unlock()
+				resp := "HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\n" + req.URL.Path
+				if _, err := server.Write([]byte(resp)); err != nil {
+					return
+				}
+			}
+		}()
+		return client, nil
+	})
+	defer vt.Close()
+
+	var reqs []*http.Request
+	for _, path := range []string{"/a", "/b"} {
+		req, err := http.NewRequest("GET", "http://volley.test"+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reqs = append(reqs, req)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	type outcome struct {
+		results []*Result
+		err     error
+	}
+	done := make(chan outcome, 1)
+	go func() {
+		results, err := vt.Pipeline(ctx, reqs)
+		done <- outcome{results, err}
+	}()
+
+	if err := vt.Wait(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	// One held connection, the leading request already served
+	if s := vt.Stats(); s.Held != 1 || s.Alive != 1 {
+		t.Fatalf("stats = %+v, want 1 held and alive", s)
+	}
+	waitFor(t, "the leading request", func() bool { return len(served()) == 1 })
+	if got := served(); got[0] != "/a" {
+		t.Fatalf("served %v before fire, want [/a]", got)
+	}
+
+	if n, err := vt.Fire(); n != 1 || err != nil {
+		t.Fatalf("Fire = %d, %v, want 1 conn released", n, err)
+	}
+	var o outcome
+	select {
+	case o = <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Pipeline hangs")
+	}
+	if o.err != nil {
+		t.Fatal(o.err)
+	}
+	for i, res := range o.results {
+		if res.Err != nil || string(res.Body) != reqs[i].URL.Path {
+			t.Fatalf("result %d = %q, %v, want %q", i, res.Body, res.Err, reqs[i].URL.Path)
+		}
+	}
+	if got := served(); len(got) != 2 || got[1] != "/b" {
+		t.Fatalf("served %v, want [/a /b]", got)
+	}
+}
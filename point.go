@@ -0,0 +1,117 @@
+package volley
+
+import (
+	"bufio"
+	"bytes"
+	"net/http"
+	"strings"
+)
+
+// StraddlePoint selects where the outgoing HTTP/1.1 request is split between
+// the bytes sent right away and the bytes withheld until Fire().
+type StraddlePoint int
+
+const (
+	// LastByte withholds the last HoldBytes bytes written (the default): the end of the
+	// body with Content-Length, the end of the header block without a body. A chunked body
+	// (Transfer-Encoding: chunked) ends with the terminating chunk "0\r\n\r\n", or with the
+	// trailer block when the request has trailers; the server reads up to its final CRLF
+	// before reporting the end of the body, so holding its last bytes holds the request.
+	LastByte StraddlePoint = iota
+	// EndOfHeaders withholds the last HoldBytes bytes of the header block
+	// (the end of its CRLF CRLF terminator) and everything after it.
+	EndOfHeaders
+	// FirstBodyByte sends the complete header block and withholds the body.
+	// For a request whose header block is not followed by body bytes in the same
+	// write (e.g. a GET), it behaves like EndOfHeaders.
+	FirstBodyByte
+)
+
+// HoldStrategy decides how a request is split between the bytes sent right away and the
+// bytes withheld until Fire(), for content-aware holding beyond the StraddlePoints.
+//
+// SplitPoint is called on every write of the request until it is released. sofar is the
+// number of bytes of the request already sent on the connection; b holds the pending bytes,
+// i.e. the bytes held so far followed by the new write. It returns how many trailing bytes
+// of b to hold (clamped to [0, len(b)]); the rest is sent. b must not be retained.
+// Calls for a connection are serialized, but one strategy serves every connection of the
+// transport, so it must not keep per-request state of its own.
+type HoldStrategy interface {
+	SplitPoint(sofar int, b []byte) int
+}
+
+// LastBytes is the default HoldStrategy: it holds the last n bytes written so far,
+// like HoldBytes with the LastByte point.
+type LastBytes int
+
+// SplitPoint implements HoldStrategy.
+func (n LastBytes) SplitPoint(sofar int, b []byte) int {
+	if int(n) > len(b) {
+		return len(b)
+	}
+	return int(n)
+}
+
+// headerEnd terminates the header block of an HTTP/1.1 request.
+var headerEnd = []byte("\r\n\r\n")
+
+// splitIndex returns where payload (the held bytes followed by the new write) splits into
+// the bytes sent now and the bytes held.
+//
+// Until the header block is complete, the other points hold all of it: headers spanning
+// several writes are scanned as a whole, and the bytes before the split are sent at once.
+// Once the split is done (pointFound), hold keeps every later byte without calling it.
+func (h *holder) splitIndex(payload []byte) int {
+	if h.strategy != nil {
+		hold := h.strategy.SplitPoint(h.sent, payload)
+		if hold < 0 {
+			hold = 0
+		} else if hold > len(payload) {
+			hold = len(payload)
+		}
+		return len(payload) - hold
+	}
+
+	if h.point == LastByte {
+		return tailIndex(payload, len(payload), h.holdN)
+	}
+
+	i := bytes.Index(payload, headerEnd)
+	if i < 0 {
+		return 0
+	}
+	h.pointFound = true
+
+	end := i + len(headerEnd)
+	if h.point == FirstBodyByte && end < len(payload) {
+		return end
+	}
+	return tailIndex(payload, end, h.holdN)
+}
+
+// awaitsContinue reports whether b is the complete header block of a request whose body
+// net/http only writes once the server answered "100 Continue", i.e. a request with a body,
+// an "Expect: 100-continue" header and a positive ExpectContinueTimeout on the transport.
+// With a zero timeout, net/http writes the body right after the headers. A standalone
+// connection has no such request. Caller must hold sc.mu.
+func (sc *StraddleConn) awaitsContinue(b []byte) bool {
+	if sc.owner == nil || sc.owner.ExpectContinueTimeout <= 0 || bytes.Index(b, headerEnd) != len(b)-len(headerEnd) {
+		return false
+	}
+	req, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(b)))
+	if err != nil {
+		return false
+	}
+	return req.ContentLength != 0 && strings.EqualFold(strings.TrimSpace(req.Header.Get("Expect")), "100-continue")
+}
+
+// tailIndex returns the index holding the last n bytes of payload[:end], or 0 if it is shorter.
+func tailIndex(payload []byte, end, n int) int {
+	if end > len(payload) {
+		end = len(payload)
+	}
+	if end < n {
+		return 0
+	}
+	return end - n
+}
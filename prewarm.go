@@ -0,0 +1,141 @@
+package volley
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"sync"
+)
+
+// Prewarm establishes n connections to each target ahead of the requests: TCP connect,
+// proxy tunnel and TLS handshake, nothing of the requests themselves. The connections
+// are pooled by scheme and host:port, and the next dials of RoundTrip for that target
+// take them instead of dialing, so by the time a batch is sent only the request bytes
+// remain between the client and the held tail.
+//
+// Targets are URLs; only the scheme (http or https) and host are used.
+// It returns the joined errors of the failed dials; the successful ones stay pooled.
+//
+// Tradeoffs:
+//   - A warm connection is idle until a request takes it, and servers close idle
+//     connections (often after 5 to 60 seconds). A request that takes a closed one fails,
+//     and net/http does not retry it. Prewarm shortly before sending the batch.
+//   - Taking a warm connection still counts as a dial of the current batch (see Stats),
+//     so Wait accounts for it like any other connection.
+//   - Warm connections are not tied to a batch: Reset leaves the pool alone.
+//     CloseIdleConnections closes them, and so does Abort.
+//   - Keep-alives stay off: the pool only feeds dials, each connection still carries
+//     one request.
+func (t *Transport) Prewarm(ctx context.Context, targets []string, n int) error {
+	type target struct{ scheme, addr string }
+	var dsts []target
+	for _, raw := range targets {
+		u, err := url.Parse(raw)
+		if err != nil {
+			return err
+		}
+		if u.Scheme != "http" && u.Scheme != "https" {
+			return fmt.Errorf("volley: prewarm %s: unsupported scheme %q", raw, u.Scheme)
+		}
+		dsts = append(dsts, target{u.Scheme, canonicalAddr(u)})
+	}
+
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		errs []error
+	)
+	for _, dst := range dsts {
+		for i := 0; i < n; i++ {
+			wg.Add(1)
+			go func(scheme, addr string) {
+				defer wg.Done()
+				c, err := t.dialWarm(ctx, scheme, addr)
+				if err != nil {
+					mu.Lock()
+					errs = append(errs, fmt.Errorf("volley: prewarm %s://%s: %w", scheme, addr, err))
+					mu.Unlock()
+					return
+				}
+				t.putWarm(scheme, addr, c)
+			}(dst.scheme, dst.addr)
+		}
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// dialWarm opens a connection as the dials of RoundTrip do, completing the TLS handshake
+// for https: net/http skips it when it takes the connection, as it is already done.
+func (t *Transport) dialWarm(ctx context.Context, scheme, addr string) (net.Conn, error) {
+	connectCtx, cancel := withTimeout(ctx, t.HandshakeTimeout)
+	defer cancel()
+
+	if scheme == "http" {
+		return t.dialTarget(connectCtx, "tcp", addr, scheme)
+	}
+
+	c, err := t.dialTLS(connectCtx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	hsCtx, cancel := withTimeout(ctx, t.HandshakeTimeout)
+	defer cancel()
+	if hs, ok := c.(interface{ HandshakeContext(context.Context) error }); ok {
+		if err := hs.HandshakeContext(hsCtx); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// putWarm adds c to the pool of scheme://addr, and to the live connections.
+func (t *Transport) putWarm(scheme, addr string, c net.Conn) {
+	t.trackLive(c)
+	t.warmMu.Lock()
+	if t.warm == nil {
+		t.warm = make(map[string][]net.Conn)
+	}
+	key := scheme + "://" + addr
+	t.warm[key] = append(t.warm[key], c)
+	t.warmMu.Unlock()
+}
+
+// takeWarm removes and returns the oldest warm connection to scheme://addr, or nil.
+// The connection leaves the live connections: wrapping it tracks it again.
+func (t *Transport) takeWarm(scheme, addr string) net.Conn {
+	t.warmMu.Lock()
+	key := scheme + "://" + addr
+	pool := t.warm[key]
+	if len(pool) == 0 {
+		t.warmMu.Unlock()
+		return nil
+	}
+	c := pool[0]
+	pool[0] = nil
+	t.warm[key] = pool[1:]
+	t.warmMu.Unlock()
+
+	t.untrackLive(c)
+	return c
+}
+
+// CloseIdleConnections closes the warm connections left by Prewarm,
+// then the idle connections of the embedded http.Transport.
+func (t *Transport) CloseIdleConnections() {
+	t.warmMu.Lock()
+	warm := t.warm
+	t.warm = nil
+	t.warmMu.Unlock()
+
+	for _, pool := range warm {
+		for _, c := range pool {
+			t.untrackLive(c)
+			c.Close()
+		}
+	}
+	t.Transport.CloseIdleConnections()
+}
@@ -0,0 +1,57 @@
+// Package promvolley exposes the state of a volley.Transport as Prometheus metrics.
+//
+// It is a separate module, so the core volley package stays free of dependencies.
+package promvolley
+
+import (
+	"github.com/ejfkdev/go-volley"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	aliveDesc = prometheus.NewDesc("volley_alive_connections",
+		"Number of established connections in the current batch.", nil, nil)
+	heldDesc = prometheus.NewDesc("volley_held_connections",
+		"Number of connections holding data, ready to fire.", nil, nil)
+	inflightDesc = prometheus.NewDesc("volley_inflight_dials",
+		"Number of dials currently connecting.", nil, nil)
+	dialsDesc = prometheus.NewDesc("volley_dials_total",
+		"Total number of tracked dials.", nil, nil)
+	firesDesc = prometheus.NewDesc("volley_fires_total",
+		"Total number of fires.", nil, nil)
+)
+
+// Collector is a prometheus.Collector reporting the state of a Transport.
+// It reads the transport counters on every scrape. The dial and fire counters
+// cover the transport's lifetime, across Reset() calls.
+type Collector struct {
+	t *volley.Transport
+}
+
+var _ prometheus.Collector = (*Collector)(nil)
+
+// NewCollector returns a Collector wrapping t.
+// Register one collector per transport; use prometheus.WrapRegistererWith
+// to tell several transports apart by label.
+func NewCollector(t *volley.Transport) *Collector {
+	return &Collector{t: t}
+}
+
+// Describe implements prometheus.Collector.
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- aliveDesc
+	ch <- heldDesc
+	ch <- inflightDesc
+	ch <- dialsDesc
+	ch <- firesDesc
+}
+
+// Collect implements prometheus.Collector.
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	s := c.t.Stats()
+	ch <- prometheus.MustNewConstMetric(aliveDesc, prometheus.GaugeValue, float64(s.Alive))
+	ch <- prometheus.MustNewConstMetric(heldDesc, prometheus.GaugeValue, float64(s.Held))
+	ch <- prometheus.MustNewConstMetric(inflightDesc, prometheus.GaugeValue, float64(s.DialInflight))
+	ch <- prometheus.MustNewConstMetric(dialsDesc, prometheus.CounterValue, float64(s.TotalDials))
+	ch <- prometheus.MustNewConstMetric(firesDesc, prometheus.CounterValue, float64(s.TotalFires))
+}
@@ -0,0 +1,22 @@
+module github.com/ejfkdev/go-volley/promvolley
+
+go 1.27
+
+require (
+	github.com/ejfkdev/go-volley v0.0.0
+	github.com/prometheus/client_golang v1.19.0
+)
+
+require (
+	github.com/beorn7/perks v1.0.1 // indirect
+	github.com/cespare/xxhash/v2 v2.2.0 // indirect
+	github.com/prometheus/client_model v0.5.0 // indirect
+	github.com/prometheus/common v0.48.0 // indirect
+	github.com/prometheus/procfs v0.12.0 // indirect
+	golang.org/x/sys v0.16.0 // indirect
+	google.golang.org/protobuf v1.32.0 // indirect
+)
+
+// No go-volley release is tagged yet: build against the parent directory until one is,
+// then require it and drop this line.
+replace github.com/ejfkdev/go-volley => ../
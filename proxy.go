@@ -0,0 +1,327 @@
+package volley
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// dialTarget connects to addr, tunneling through the proxy configured in t.Proxy (if any),
+// or to t.UnixSocket if set.
+// scheme is the scheme of the target ("http" or "https"); it is only used to select the proxy.
+//
+// The returned conn carries the target's byte stream: the proxy handshake has already
+// completed, so the straddle wrapping applied by the caller never touches it.
+// TCPNoDelay is applied to it, whether or not the dial is tracked.
+func (t *Transport) dialTarget(ctx context.Context, network, addr, scheme string) (net.Conn, error) {
+	var proxyURL *url.URL
+	var err error
+	if t.UnixSocket != "" {
+		network, addr = "unix", t.UnixSocket
+	} else if proxyURL, err = t.proxyFor(ctx, scheme, addr); err != nil {
+		return nil, err
+	}
+
+	release, err := t.acquireDial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	var conn net.Conn
+	switch {
+	case proxyURL == nil:
+		conn, err = t.dialBase(ctx, network, addr)
+	case proxyURL.Scheme == "http", proxyURL.Scheme == "https":
+		conn, err = t.dialConnect(ctx, network, addr, proxyURL)
+	case proxyURL.Scheme == "socks5", proxyURL.Scheme == "socks5h":
+		conn, err = t.dialSOCKS5(ctx, network, addr, proxyURL)
+	default:
+		return nil, fmt.Errorf("volley: unsupported proxy scheme %q", proxyURL.Scheme)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	setNoDelay(conn, t.TCPNoDelay)
+	return conn, nil
+}
+
+// acquireDial waits for a dial slot if MaxConcurrentDials is set, and returns the function
+// giving it back.
+func (t *Transport) acquireDial(ctx context.Context) (func(), error) {
+	if t.MaxConcurrentDials <= 0 {
+		return func() {}, nil
+	}
+	t.dialSemOnce.Do(func() {
+		t.dialSem = make(chan struct{}, t.MaxConcurrentDials)
+	})
+
+	select {
+	case t.dialSem <- struct{}{}:
+		return func() { <-t.dialSem }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// proxyFor returns the proxy URL for the target, or nil for a direct connection.
+func (t *Transport) proxyFor(ctx context.Context, scheme, addr string) (*url.URL, error) {
+	if t.Proxy == nil {
+		return nil, nil
+	}
+
+	// Proxy takes a *http.Request, so create a fake one to pass it (as net/http does).
+	req := (&http.Request{
+		Method:     "GET",
+		URL:        &url.URL{Scheme: scheme, Host: addr, Path: "/"},
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     make(http.Header),
+		Host:       addr,
+	}).WithContext(ctx)
+	return t.Proxy(req)
+}
+
+// dialConnect opens an HTTP CONNECT tunnel to addr through proxyURL.
+func (t *Transport) dialConnect(ctx context.Context, network, addr string, proxyURL *url.URL) (net.Conn, error) {
+	proxyAddr := canonicalAddr(proxyURL)
+	conn, err := t.dialBase(ctx, network, proxyAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	if proxyURL.Scheme == "https" {
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: proxyURL.Hostname()})
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		conn = tlsConn
+	}
+
+	// Bound the proxy handshake by ctx
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+		defer conn.SetDeadline(time.Time{})
+	}
+
+	hdr := make(http.Header)
+	for k, v := range t.Transport.ProxyConnectHeader {
+		hdr[k] = v
+	}
+	if u := proxyURL.User; u != nil {
+		password, _ := u.Password()
+		auth := base64.StdEncoding.EncodeToString([]byte(u.Username() + ":" + password))
+		hdr.Set("Proxy-Authorization", "Basic "+auth)
+	}
+
+	connectReq := &http.Request{
+		Method: "CONNECT",
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: hdr,
+	}
+	if err := connectReq.Write(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, connectReq)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, fmt.Errorf("volley: proxy CONNECT %s: %s", addr, resp.Status)
+	}
+
+	// The proxy must not send anything before the client speaks
+	if br.Buffered() > 0 {
+		conn.Close()
+		return nil, fmt.Errorf("volley: proxy CONNECT %s: unexpected data after response", addr)
+	}
+	return conn, nil
+}
+
+// SOCKS5 protocol constants (RFC 1928, RFC 1929).
+const (
+	socks5Version      = 0x05
+	socks5NoAuth       = 0x00
+	socks5UserPass     = 0x02
+	socks5NoAcceptable = 0xff
+	socks5Connect      = 0x01
+	socks5IPv4         = 0x01
+	socks5Domain       = 0x03
+	socks5IPv6         = 0x04
+)
+
+// socks5Replies describes the failure codes of a SOCKS5 reply.
+var socks5Replies = map[byte]string{
+	0x01: "general failure",
+	0x02: "connection not allowed by ruleset",
+	0x03: "network unreachable",
+	0x04: "host unreachable",
+	0x05: "connection refused",
+	0x06: "TTL expired",
+	0x07: "command not supported",
+	0x08: "address type not supported",
+}
+
+// dialSOCKS5 opens a SOCKS5 tunnel to addr through proxyURL, authenticating with the
+// user:password of proxyURL if any. The host name is sent to the proxy, which resolves it,
+// for socks5:// as for socks5h:// (as net/http does).
+func (t *Transport) dialSOCKS5(ctx context.Context, network, addr string, proxyURL *url.URL) (net.Conn, error) {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port < 1 || port > 0xffff {
+		return nil, fmt.Errorf("volley: socks5 proxy: bad port %q", portStr)
+	}
+
+	proxyAddr := proxyURL.Host
+	if proxyURL.Port() == "" {
+		proxyAddr = net.JoinHostPort(proxyURL.Hostname(), "1080")
+	}
+	conn, err := t.dialBase(ctx, network, proxyAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	// Bound the proxy handshake by ctx
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+		defer conn.SetDeadline(time.Time{})
+	}
+
+	if err := socks5Handshake(conn, host, port, proxyURL.User); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("volley: socks5 proxy %s: %w", addr, err)
+	}
+	return conn, nil
+}
+
+// socks5Handshake negotiates the authentication and the CONNECT to host:port on conn.
+func socks5Handshake(conn net.Conn, host string, port int, user *url.Userinfo) error {
+	methods := []byte{socks5NoAuth}
+	if user != nil {
+		methods = append(methods, socks5UserPass)
+	}
+	greeting := append([]byte{socks5Version, byte(len(methods))}, methods...)
+	if _, err := conn.Write(greeting); err != nil {
+		return err
+	}
+
+	var buf [4]byte
+	if _, err := io.ReadFull(conn, buf[:2]); err != nil {
+		return err
+	}
+	if buf[0] != socks5Version {
+		return fmt.Errorf("unexpected protocol version %d", buf[0])
+	}
+	switch buf[1] {
+	case socks5NoAuth:
+	case socks5UserPass:
+		if user == nil {
+			return errors.New("proxy requires authentication")
+		}
+		password, _ := user.Password()
+		name := user.Username()
+		if len(name) == 0 || len(name) > 255 || len(password) > 255 {
+			return errors.New("invalid username or password length")
+		}
+		auth := []byte{0x01, byte(len(name))}
+		auth = append(auth, name...)
+		auth = append(auth, byte(len(password)))
+		auth = append(auth, password...)
+		if _, err := conn.Write(auth); err != nil {
+			return err
+		}
+		if _, err := io.ReadFull(conn, buf[:2]); err != nil {
+			return err
+		}
+		if buf[1] != 0x00 {
+			return errors.New("authentication failed")
+		}
+	case socks5NoAcceptable:
+		return errors.New("no acceptable authentication method")
+	default:
+		return fmt.Errorf("unsupported authentication method %d", buf[1])
+	}
+
+	req := []byte{socks5Version, socks5Connect, 0x00}
+	if ip := net.ParseIP(host); ip == nil {
+		if len(host) > 255 {
+			return fmt.Errorf("host name too long: %q", host)
+		}
+		req = append(req, socks5Domain, byte(len(host)))
+		req = append(req, host...)
+	} else if ip4 := ip.To4(); ip4 != nil {
+		req = append(req, socks5IPv4)
+		req = append(req, ip4...)
+	} else {
+		req = append(req, socks5IPv6)
+		req = append(req, ip.To16()...)
+	}
+	req = append(req, byte(port>>8), byte(port))
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+
+	// Reply: version, status, reserved, then the bound address, which is skipped
+	if _, err := io.ReadFull(conn, buf[:4]); err != nil {
+		return err
+	}
+	if buf[0] != socks5Version {
+		return fmt.Errorf("unexpected protocol version %d", buf[0])
+	}
+	if buf[1] != 0x00 {
+		if msg, ok := socks5Replies[buf[1]]; ok {
+			return errors.New(msg)
+		}
+		return fmt.Errorf("unknown reply code %d", buf[1])
+	}
+	var skip int
+	switch buf[3] {
+	case socks5IPv4:
+		skip = net.IPv4len
+	case socks5IPv6:
+		skip = net.IPv6len
+	case socks5Domain:
+		if _, err := io.ReadFull(conn, buf[:1]); err != nil {
+			return err
+		}
+		skip = int(buf[0])
+	default:
+		return fmt.Errorf("unknown address type %d", buf[3])
+	}
+	_, err := io.CopyN(io.Discard, conn, int64(skip+2))
+	return err
+}
+
+// canonicalAddr returns host:port of u, adding the scheme's default port.
+func canonicalAddr(u *url.URL) string {
+	port := u.Port()
+	if port == "" {
+		port = "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+	}
+	return net.JoinHostPort(u.Hostname(), port)
+}
@@ -0,0 +1,230 @@
+package volley
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// listen starts a loopback listener serving each conn with serve, closed with the test.
+func listen(t *testing.T, serve func(c net.Conn)) net.Listener {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go serve(c)
+		}
+	}()
+	return ln
+}
+
+// tunnel copies between c and the target until either side closes.
+func tunnel(c, target net.Conn) {
+	defer c.Close()
+	defer target.Close()
+	go io.Copy(target, c)
+	io.Copy(c, target)
+}
+
+// connectProxy starts an HTTP CONNECT proxy and returns its address, counting in connects
+// the CONNECT requests it read in full.
+func connectProxy(t *testing.T, connects *int32) string {
+	ln := listen(t, func(c net.Conn) {
+		br := bufio.NewReader(c)
+		req, err := http.ReadRequest(br)
+		if err != nil || req.Method != http.MethodConnect {
+			c.Close()
+			return
+		}
+		atomic.AddInt32(connects, 1)
+		target, err := net.Dial("tcp", req.Host)
+		if err != nil {
+			io.WriteString(c, "HTTP/1.1 502 Bad Gateway\r\n\r\n")
+			c.Close()
+			return
+		}
+		io.WriteString(c, "HTTP/1.1 200 Connection established\r\n\r\n")
+		tunnel(c, target)
+	})
+	return ln.Addr().String()
+}
+
+func TestConnectProxy(t *testing.T) {
+	var served int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&served, 1)
+	}))
+	defer srv.Close()
+	var connects int32
+	proxy := connectProxy(t, &connects)
+
+	const want = 3
+	vt := NewTransport(WithProxy("http://" + proxy))
+	defer vt.Close()
+	var errcs []<-chan error
+	for i := 0; i < want; i++ {
+		errcs = append(errcs, get(vt, srv.URL))
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := vt.Wait(ctx, want); err != nil {
+		t.Fatal(err)
+	}
+
+	// The tunnels are up, the requests through them held
+	if got := atomic.LoadInt32(&connects); got != want {
+		t.Fatalf("proxy read %d CONNECT requests, want %d", got, want)
+	}
+	if got := atomic.LoadInt32(&served); got != 0 {
+		t.Fatalf("served %d before fire, want 0", got)
+	}
+
+	vt.Fire()
+	for _, errc := range errcs {
+		if err := recvErr(t, errc); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := atomic.LoadInt32(&served); got != want {
+		t.Fatalf("served %d after fire, want %d", got, want)
+	}
+}
+
+// socksServer configures socks5Proxy.
+type socksServer struct {
+	// user and password, if set, are required (RFC 1929); otherwise no auth is offered.
+	user, password string
+	// reply, if not zero, is the failure code answering every CONNECT.
+	reply byte
+	// atyp records the address type of the last CONNECT.
+	atyp int32
+}
+
+// socks5Proxy starts a SOCKS5 proxy configured by s and returns its address.
+func socks5Proxy(t *testing.T, s *socksServer) string {
+	ln := listen(t, func(c net.Conn) {
+		defer c.Close()
+		var hdr [2]byte
+		if _, err := io.ReadFull(c, hdr[:]); err != nil {
+			return
+		}
+		methods := make([]byte, hdr[1])
+		if _, err := io.ReadFull(c, methods); err != nil {
+			return
+		}
+		method := byte(socks5NoAuth)
+		if s.user != "" {
+			method = socks5UserPass
+		}
+		c.Write([]byte{socks5Version, method})
+
+		if s.user != "" {
+			br := bufio.NewReader(c)
+			ver, _ := br.ReadByte()
+			n, _ := br.ReadByte()
+			user := make([]byte, n)
+			io.ReadFull(br, user)
+			n, _ = br.ReadByte()
+			password := make([]byte, n)
+			io.ReadFull(br, password)
+			if ver != 0x01 || string(user) != s.user || string(password) != s.password {
+				c.Write([]byte{0x01, 0x01})
+				return
+			}
+			c.Write([]byte{0x01, 0x00})
+		}
+
+		var req [4]byte
+		if _, err := io.ReadFull(c, req[:]); err != nil {
+			return
+		}
+		atomic.StoreInt32(&s.atyp, int32(req[3]))
+		var host string
+		switch req[3] {
+		case socks5IPv4:
+			ip := make([]byte, net.IPv4len)
+			io.ReadFull(c, ip)
+			host = net.IP(ip).String()
+		case socks5Domain:
+			var n [1]byte
+			io.ReadFull(c, n[:])
+			name := make([]byte, n[0])
+			io.ReadFull(c, name)
+			host = string(name)
+		default:
+			return
+		}
+		var port [2]byte
+		io.ReadFull(c, port[:])
+		addr := net.JoinHostPort(host, strconv.Itoa(int(port[0])<<8|int(port[1])))
+
+		reply := s.reply
+		var target net.Conn
+		if reply == 0 {
+			var err error
+			if target, err = net.Dial("tcp", addr); err != nil {
+				reply = 0x05
+			}
+		}
+		// Bound address 0.0.0.0:0
+		c.Write([]byte{socks5Version, reply, 0x00, socks5IPv4, 0, 0, 0, 0, 0, 0})
+		if reply == 0 {
+			tunnel(c, target)
+		}
+	})
+	return ln.Addr().String()
+}
+
+func TestSOCKS5Proxy(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+	_, port, _ := net.SplitHostPort(srv.Listener.Addr().String())
+
+	cases := []struct {
+		name     string
+		server   socksServer
+		userinfo string
+		target   string
+		atyp     int32
+		wantErr  string
+	}{
+		{"no auth, IP address", socksServer{}, "", "127.0.0.1", socks5IPv4, ""},
+		{"user/password, hostname", socksServer{user: "u", password: "p"}, "u:p@", "localhost", socks5Domain, ""},
+		{"wrong password", socksServer{user: "u", password: "p"}, "u:x@", "127.0.0.1", 0, "authentication failed"},
+		{"failure reply", socksServer{reply: 0x02}, "", "127.0.0.1", socks5IPv4, "connection not allowed by ruleset"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			proxy := socks5Proxy(t, &c.server)
+			vt := NewTransport(WithHoldBytes(0), WithProxy("socks5://"+c.userinfo+proxy))
+			defer vt.Close()
+
+			err := recvErr(t, get(vt, "http://"+net.JoinHostPort(c.target, port)+"/"))
+			switch {
+			case c.wantErr == "" && err != nil:
+				t.Fatalf("request: %v", err)
+			case c.wantErr != "" && (err == nil || !strings.Contains(err.Error(), c.wantErr)):
+				t.Fatalf("err = %v, want %q", err, c.wantErr)
+			}
+			if got := atomic.LoadInt32(&c.server.atyp); got != c.atyp {
+				t.Fatalf("address type %d, want %d", got, c.atyp)
+			}
+		})
+	}
+}
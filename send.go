@@ -0,0 +1,131 @@
+package volley
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync"
+)
+
+// Volley sends a batch of requests in one volley: it wraps a Transport and an
+// http.Client, launches one goroutine per request, waits until they are armed,
+// fires and collects the responses. It covers the common case in one call;
+// Transport stays available for finer control.
+//
+//	v := volley.NewVolley()
+//	for i := 0; i < 20; i++ {
+//		req, _ := http.NewRequest("POST", target, strings.NewReader(body))
+//		v.Add(req)
+//	}
+//	results, err := v.Send(ctx)
+type Volley struct {
+	// Transport and Client are the transport and client the requests go through.
+	// Client may be adjusted (e.g. its Timeout or CheckRedirect) before Send.
+	Transport *Transport
+	Client    *http.Client
+
+	reqs []*http.Request
+	mu   sync.Mutex
+}
+
+// Result is the outcome of one request of a volley.
+type Result struct {
+	// Request is the request, as passed to Add.
+	Request *http.Request
+	// Response is the response, nil on error. Its body has been read into Body and closed.
+	Response *http.Response
+	Body     []byte
+	// Err is the error of the request or of reading its body.
+	Err error
+	// Timing is the timing record of the request (see RequestTiming). With redirects,
+	// it is the record of the first request.
+	Timing RequestTiming
+}
+
+// NewVolley creates a Volley whose Transport is configured by opts (see NewTransport).
+func NewVolley(opts ...Option) *Volley {
+	t := NewTransport(opts...)
+	return &Volley{
+		Transport: t,
+		Client:    &http.Client{Transport: t},
+	}
+}
+
+// Add queues req for the next Send.
+func (v *Volley) Add(req *http.Request) {
+	v.mu.Lock()
+	v.reqs = append(v.reqs, req)
+	v.mu.Unlock()
+}
+
+// Send sends the queued requests as one batch and returns their results in the order they
+// were added. It resets the transport first, waits until every request is armed (see
+// Transport.Wait), fires, and waits for all the responses. The queue is emptied, so the
+// Volley can be reused for another batch.
+//
+// ctx bounds the wait before the fire, while each request keeps its own context.
+// If the wait fails, the held requests are aborted (see Transport.Abort), the requests
+// still dialing or waiting are canceled, and the wait error is returned along with
+// the results.
+func (v *Volley) Send(ctx context.Context) ([]*Result, error) {
+	v.mu.Lock()
+	reqs := v.reqs
+	v.reqs = nil
+	v.mu.Unlock()
+
+	t := v.Transport
+	t.Reset()
+
+	results := make([]*Result, len(reqs))
+	slots := make([]*RequestTiming, len(reqs))
+	cancels := make([]context.CancelFunc, len(reqs))
+	var wg sync.WaitGroup
+	for i, req := range reqs {
+		results[i] = &Result{Request: req}
+		// A failed wait cancels the request, which may be stuck in a dial Abort cannot reach
+		rctx, cancel := context.WithCancel(withTimingSlot(req.Context(), &slots[i]))
+		cancels[i] = cancel
+		wg.Add(1)
+		go func(i int, req *http.Request) {
+			defer wg.Done()
+			v.do(req, results[i])
+		}(i, req.WithContext(rctx))
+	}
+	defer func() {
+		for _, cancel := range cancels {
+			cancel()
+		}
+	}()
+
+	err := t.Wait(ctx, len(reqs))
+	if err != nil {
+		t.Abort()
+		for _, cancel := range cancels {
+			cancel()
+		}
+	} else {
+		t.Fire()
+	}
+	wg.Wait()
+
+	t.timingMu.Lock()
+	for i, rec := range slots {
+		if rec != nil {
+			results[i].Timing = *rec
+		}
+	}
+	t.timingMu.Unlock()
+	return results, err
+}
+
+// do sends req and fills res.
+func (v *Volley) do(req *http.Request, res *Result) {
+	resp, err := v.Client.Do(req)
+	if err != nil {
+		res.Err = err
+		return
+	}
+	res.Response = resp
+	res.Body, res.Err = io.ReadAll(resp.Body)
+	resp.Body.Close()
+}
@@ -0,0 +1,44 @@
+package volley
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestSendCancelsRequestsOnWaitFailure(t *testing.T) {
+	// The dial never completes on its own: only the request context ends it
+	v := NewVolley(WithBaseDialer(func(ctx context.Context, network, addr string) (net.Conn, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}), WithHandshakeTimeout(time.Minute))
+	req, err := http.NewRequest("GET", "http://volley.test/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v.Add(req)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	done := make(chan struct{})
+	var results []*Result
+	go func() {
+		defer close(done)
+		results, err = v.Send(ctx)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Send hangs after its wait failed")
+	}
+
+	if err == nil {
+		t.Fatal("Send returned no error, want the wait timeout")
+	}
+	if len(results) != 1 || results[0].Err == nil {
+		t.Fatalf("results = %+v, want the canceled request", results)
+	}
+}
@@ -0,0 +1,31 @@
+package volley
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+)
+
+// WithSlog logs the debug lines of the transport (see Logger) to l at slog.LevelDebug, as
+// structured records: the message names the transition ("dial start", "conn held",
+// "fire"...) and the attributes carry its details (addr, label, counts, trigger time).
+// Nothing is logged when no Logger is set.
+func WithSlog(l *slog.Logger) Option {
+	return WithLogger(slogLogger{l})
+}
+
+// slogLogger adapts a *slog.Logger to Logger.
+type slogLogger struct {
+	l *slog.Logger
+}
+
+func (s slogLogger) Debugf(format string, args ...any) {
+	s.l.Debug(fmt.Sprintf(format, args...))
+}
+
+func (s slogLogger) logAttrs(msg string, kv []any) {
+	if !s.l.Enabled(context.Background(), slog.LevelDebug) {
+		return
+	}
+	s.l.Debug("volley: "+msg, kv...)
+}
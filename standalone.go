@@ -0,0 +1,68 @@
+package volley
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"time"
+)
+
+// NewStraddleConn wraps c outside of any HTTP transport, e.g. for a raw TCP protocol:
+// the bytes written to it are split and held exactly as for an HTTP request, and the
+// held bytes are written once fireCh is closed (or receives a value). From then on the
+// connection passes writes through. opts configure the holding (WithHoldBytes,
+// WithStraddlePoint, WithHoldStrategy); the other options have no effect.
+//
+//	fire := make(chan struct{})
+//	for _, c := range conns {
+//		sc := volley.NewStraddleConn(c, fire)
+//		go sc.Write(msg) // sends all of msg but its last byte
+//	}
+//	close(fire) // every connection writes its last byte
+//
+// Writes keep going through the holding until the fire, so a message may span several
+// writes. The connection has no transport: it owns only its hold state, and a goroutine
+// waiting for fireCh, which exits on Close, unless holding is disabled (WithHoldBytes(0)).
+func NewStraddleConn(c net.Conn, fireCh <-chan struct{}, opts ...Option) *StraddleConn {
+	var addr string
+	if ra := c.RemoteAddr(); ra != nil {
+		addr = ra.String()
+	}
+	fired := make(chan struct{})
+	sc := &StraddleConn{
+		Conn:   c,
+		holder: standaloneHolder(opts),
+		fireCh: fired,
+		Addr:   addr,
+	}
+	if !sc.holds() {
+		sc.bypass = 1
+		return sc
+	}
+	stop := make(chan struct{})
+	sc.stop = stop
+
+	go func() {
+		select {
+		case <-fireCh:
+			close(fired)
+			sc.release(time.Now())
+		case <-stop:
+		}
+	}()
+	return sc
+}
+
+// standaloneHolder returns the hold state configured by opts. Options only set fields, so
+// they are applied to a bare Transport, with the defaults of the hold fields and an
+// http.Transport for the TLS options to write to, instead of a working one.
+func standaloneHolder(opts []Option) holder {
+	t := &Transport{
+		HoldBytes: 1,
+		Transport: &http.Transport{TLSClientConfig: &tls.Config{}},
+	}
+	for _, opt := range opts {
+		opt(t)
+	}
+	return t.newHolder()
+}
@@ -0,0 +1,92 @@
+package volley
+
+import (
+	"crypto/tls"
+	"net"
+	"testing"
+)
+
+// readN reads exactly n bytes from c.
+func readN(t *testing.T, c net.Conn, n int) string {
+	t.Helper()
+	buf := make([]byte, n)
+	for got := 0; got < n; {
+		m, err := c.Read(buf[got:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		got += m
+	}
+	return string(buf)
+}
+
+func TestStandaloneConn(t *testing.T) {
+	fire := make(chan struct{})
+	var servers []net.Conn
+	var scs []*StraddleConn
+	for i := 0; i < 3; i++ {
+		c, s := net.Pipe()
+		defer s.Close()
+		servers = append(servers, s)
+		scs = append(scs, NewStraddleConn(c, fire, WithHoldBytes(2)))
+	}
+
+	for _, sc := range scs {
+		if sc.owner != nil {
+			t.Fatal("standalone conn has a transport")
+		}
+		go sc.Write([]byte("PING\n"))
+	}
+	for _, s := range servers {
+		if got := readN(t, s, 3); got != "PIN" {
+			t.Fatalf("before fire: %q, want %q", got, "PIN")
+		}
+	}
+
+	close(fire)
+	for _, s := range servers {
+		if got := readN(t, s, 2); got != "G\n" {
+			t.Fatalf("on fire: %q, want %q", got, "G\n")
+		}
+	}
+
+	// Released: writes pass through
+	for i, sc := range scs {
+		waitFor(t, "the release", func() bool { return !sc.ReleasedAt().IsZero() })
+		if err := sc.Err(); err != nil {
+			t.Fatalf("Err = %v", err)
+		}
+		go sc.Write([]byte("x"))
+		if got := readN(t, servers[i], 1); got != "x" {
+			t.Fatalf("after fire: %q, want %q", got, "x")
+		}
+		sc.Close()
+	}
+}
+
+func TestStandaloneConnCloseBeforeFire(t *testing.T) {
+	c, s := net.Pipe()
+	defer s.Close()
+	sc := NewStraddleConn(c, make(chan struct{}))
+	if err := sc.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+}
+
+func TestStandaloneConnOptions(t *testing.T) {
+	c, s := net.Pipe()
+	defer s.Close()
+	// Options beyond the holding ones are accepted, and ignored
+	sc := NewStraddleConn(c, make(chan struct{}), WithHoldBytes(3), WithStraddlePoint(EndOfHeaders),
+		WithTLSConfig(&tls.Config{}), WithInsecureSkipVerify(true), WithHTTP2(true), WithKeepAlive(true))
+	defer sc.Close()
+
+	if sc.holdN != 3 || sc.point != EndOfHeaders {
+		t.Fatalf("holdN = %d, point = %v, want 3, EndOfHeaders", sc.holdN, sc.point)
+	}
+	d := NewStraddleConn(c, make(chan struct{}))
+	defer d.Close()
+	if d.holdN != 1 {
+		t.Fatalf("default holdN = %d, want 1", d.holdN)
+	}
+}
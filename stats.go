@@ -0,0 +1,337 @@
+package volley
+
+import (
+	"math"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// Stats is a point-in-time snapshot of the transport state.
+type Stats struct {
+	// DialStarted is the number of dial attempts started,
+	// plus the kept-alive connections reused by the batch (see WithKeepAlive).
+	DialStarted int
+	// DialInflight is the number of dials currently connecting.
+	DialInflight int
+	// Alive is the number of established connections.
+	Alive int
+	// Held is the number of connections holding data, ready to fire.
+	Held int
+	// Fired reports whether Fire() has been called.
+	Fired bool
+	// Released is the number of connections of the batch whose held bytes were written,
+	// by a fire (including FireN and FireHost) or by a write landing after it. Unlike Held,
+	// which stays as it was at the fire, it counts the bytes that actually went out.
+	Released int
+	// TotalDials and TotalFires count the tracked dials and the fires (Fire or FireStaggered)
+	// since the transport was created. Unlike the other fields, Reset() does not clear them.
+	TotalDials int
+	TotalFires int
+	// HeldLabels lists the labels of the held connections (see WithLabel), in the order they were armed.
+	HeldLabels []string
+}
+
+// Stats returns a snapshot of the transport state.
+func (t *Transport) Stats() Stats {
+	s := Stats{
+		DialStarted:  int(atomic.LoadInt32(&t.dialStartCount)),
+		DialInflight: int(atomic.LoadInt32(&t.dialInflight)),
+		Alive:        int(atomic.LoadInt32(&t.aliveCount)),
+		Held:         int(atomic.LoadInt32(&t.heldCount)),
+		Fired:        atomic.LoadInt32(&t.fired) == 1,
+		Released:     int(atomic.LoadInt32(&t.releasedCount)),
+		TotalDials:   int(atomic.LoadInt32(&t.totalDials)),
+		TotalFires:   int(atomic.LoadInt32(&t.totalFires)),
+	}
+
+	t.heldMu.Lock()
+	for _, sc := range t.heldConns {
+		s.HeldLabels = append(s.HeldLabels, sc.Label)
+	}
+	t.heldMu.Unlock()
+
+	return s
+}
+
+// Unhealthy returns the held connections the server sent data on, or closed, before the
+// fire (see Transport.ReadinessProbe), in the order they were armed. It tells whether the
+// servers are still waiting for the held bytes, whether or not the fire skips these.
+func (t *Transport) Unhealthy() []*StraddleConn {
+	t.heldMu.Lock()
+	defer t.heldMu.Unlock()
+
+	var out []*StraddleConn
+	for _, sc := range t.heldConns {
+		if atomic.LoadInt32(&sc.unhealthy) == 1 {
+			out = append(out, sc)
+		}
+	}
+	return out
+}
+
+// IsHeld reports whether a connection labeled label (see WithLabel) is currently holding
+// its data, ready to fire. With several connections sharing the label, any of them counts.
+func (t *Transport) IsHeld(label string) bool {
+	t.heldMu.Lock()
+	defer t.heldMu.Unlock()
+
+	for _, sc := range t.heldConns {
+		if sc.Label == label {
+			return true
+		}
+	}
+	return false
+}
+
+// FireJitter summarizes how tightly the held bytes were flushed after firing.
+// Each sample is the delay between the triggering Fire/FireN call and the moment
+// a connection started writing its held bytes. It returns the smallest and largest
+// delay and their standard deviation; all zero if nothing was released yet.
+//
+// Samples are cleared by Reset().
+func (t *Transport) FireJitter() (min, max, stddev time.Duration) {
+	t.samplesMu.Lock()
+	defer t.samplesMu.Unlock()
+
+	if len(t.releaseLags) == 0 {
+		return 0, 0, 0
+	}
+
+	min, max = t.releaseLags[0], t.releaseLags[0]
+	var sum float64
+	for _, d := range t.releaseLags {
+		if d < min {
+			min = d
+		}
+		if d > max {
+			max = d
+		}
+		sum += float64(d)
+	}
+
+	mean := sum / float64(len(t.releaseLags))
+	var variance float64
+	for _, d := range t.releaseLags {
+		diff := float64(d) - mean
+		variance += diff * diff
+	}
+	variance /= float64(len(t.releaseLags))
+
+	return min, max, time.Duration(math.Sqrt(variance))
+}
+
+// FireToWrite returns, per connection released since the last Reset() in release order,
+// the delay between the triggering Fire/FireN call and the return of the write of its
+// held bytes. Unlike FireJitter, which measures when the writes started, it includes the
+// write itself: a late Fire shows in every sample, a slow release path in some of them.
+func (t *Transport) FireToWrite() []time.Duration {
+	t.samplesMu.Lock()
+	defer t.samplesMu.Unlock()
+	return append([]time.Duration(nil), t.fireToWrite...)
+}
+
+// FireToWriteStats summarizes FireToWrite (see DialDurationStats).
+func (t *Transport) FireToWriteStats() DurationStats {
+	return durationStats(t.FireToWrite())
+}
+
+// fireTime returns the instant of the last Fire() broadcast.
+func (t *Transport) fireTime() time.Time {
+	t.samplesMu.Lock()
+	defer t.samplesMu.Unlock()
+	return t.firedAt
+}
+
+// recordRelease stores the release instant of sc and its delays after trigger, until the
+// write of the held bytes started (at) and returned (done). Caller must hold sc.mu.
+func (t *Transport) recordRelease(sc *StraddleConn, at, done, trigger time.Time) {
+	sc.releasedAt = at
+
+	t.samplesMu.Lock()
+	t.releaseLags = append(t.releaseLags, at.Sub(trigger))
+	t.fireToWrite = append(t.fireToWrite, done.Sub(trigger))
+	t.releaseTimes = append(t.releaseTimes, at)
+	t.samplesMu.Unlock()
+}
+
+// ReleaseTimings returns the instants the held bytes were written, one per released
+// connection in release order, since the last Reset(). They come from the transport's
+// clock, time.Now, so they carry a monotonic clock reading: differences between them
+// (e.g. the spread of a volley) are immune to wall-clock adjustments.
+func (t *Transport) ReleaseTimings() []time.Time {
+	t.samplesMu.Lock()
+	defer t.samplesMu.Unlock()
+	return append([]time.Time(nil), t.releaseTimes...)
+}
+
+// DurationStats summarizes a set of durations. All fields are zero if there is none.
+type DurationStats struct {
+	Count  int
+	Min    time.Duration
+	Median time.Duration
+	P99    time.Duration
+	Max    time.Duration
+}
+
+// dialDone records the duration of a successful tracked dial of batch gen. Dials of a
+// fired batch are not recorded.
+func (t *Transport) dialDone(gen uint32, d time.Duration) {
+	if atomic.LoadInt32(&t.fired) == 1 {
+		return
+	}
+	t.inGen(gen, func() {
+		t.samplesMu.Lock()
+		t.dialDurations = append(t.dialDurations, d)
+		t.samplesMu.Unlock()
+	})
+}
+
+// DialDurations returns the durations of the tracked dials that succeeded since the last
+// Reset(), in the order they completed: from the start of the dial to the established
+// connection, TLS handshake included. Dials completing after Fire are not recorded.
+// The slowest dial bounds how early Wait can return.
+func (t *Transport) DialDurations() []time.Duration {
+	t.samplesMu.Lock()
+	defer t.samplesMu.Unlock()
+	return append([]time.Duration(nil), t.dialDurations...)
+}
+
+// DialDurationStats summarizes DialDurations. Percentiles use the nearest-rank method.
+func (t *Transport) DialDurationStats() DurationStats {
+	return durationStats(t.DialDurations())
+}
+
+// durationStats summarizes ds, sorting it in place.
+func durationStats(ds []time.Duration) DurationStats {
+	if len(ds) == 0 {
+		return DurationStats{}
+	}
+	sort.Slice(ds, func(i, j int) bool { return ds[i] < ds[j] })
+
+	rank := func(p float64) time.Duration {
+		i := int(math.Ceil(p*float64(len(ds)))) - 1
+		if i < 0 {
+			i = 0
+		}
+		return ds[i]
+	}
+	return DurationStats{
+		Count:  len(ds),
+		Min:    ds[0],
+		Median: rank(0.5),
+		P99:    rank(0.99),
+		Max:    ds[len(ds)-1],
+	}
+}
+
+// ConnResult is the record of one connection of a batch (see Transport.Results).
+type ConnResult struct {
+	// Label and Addr identify the connection, as on StraddleConn.
+	Label string
+	Addr  string
+	// RemoteAddr is the remote address of the underlying connection: the proxy, if any.
+	RemoteAddr string
+	// Held reports whether the connection held a request, from HeldAt on.
+	Held   bool
+	HeldAt time.Time
+	// Released reports whether its held bytes were written, at ReleasedAt.
+	Released   bool
+	ReleasedAt time.Time
+	// Closed reports whether the connection was closed. A connection Held and Closed but
+	// not Released closed early: its request never completed.
+	Closed bool
+	// Unhealthy reports whether the server sent data or closed the connection while it
+	// was held (see Transport.ReadinessProbe).
+	Unhealthy bool
+	// Err is the error of the write of the held bytes, on fire or on Close, if it failed
+	// (see StraddleConn.Err).
+	Err error
+}
+
+// Results returns a record per connection of the batch, dialed or rejoined (see
+// WithKeepAlive) since the last Reset(), in the order they were established. Each record
+// is a copy of the state of the connection at the call, so a post-mortem after the
+// responses are read tells which requests were held, released or lost.
+//
+// The transport keeps one small record per connection until Reset, whatever its state:
+// a transport reused across batches should be Reset between them. Dials completing after
+// the fire pass through untracked (see Fire) and get no record.
+func (t *Transport) Results() []ConnResult {
+	t.samplesMu.Lock()
+	defer t.samplesMu.Unlock()
+
+	out := make([]ConnResult, len(t.results))
+	for i, r := range t.results {
+		out[i] = *r
+	}
+	return out
+}
+
+// addResult starts the record of sc in the current batch. Caller must hold genMu
+// (for reading) with sc in the current generation.
+func (t *Transport) addResult(sc *StraddleConn) {
+	r := &ConnResult{Label: sc.Label, Addr: sc.Addr}
+	if addr := sc.Conn.RemoteAddr(); addr != nil {
+		r.RemoteAddr = addr.String()
+	}
+
+	t.samplesMu.Lock()
+	sc.result = r
+	t.results = append(t.results, r)
+	t.samplesMu.Unlock()
+}
+
+// noteResult applies fn to the record of sc, if it has one. Caller must hold sc.mu.
+func (sc *StraddleConn) noteResult(fn func(r *ConnResult)) {
+	t := sc.owner
+	if t == nil {
+		return
+	}
+	t.samplesMu.Lock()
+	if sc.result != nil {
+		fn(sc.result)
+	}
+	t.samplesMu.Unlock()
+}
+
+// ReleaseErrors returns the errors of held-byte writes that failed on fire,
+// i.e. connections whose final bytes never reached the server. Cleared by Reset().
+// StraddleConn.Err reports the same error on the connection itself.
+func (t *Transport) ReleaseErrors() []error {
+	t.errMu.Lock()
+	defer t.errMu.Unlock()
+	return append([]error(nil), t.releaseErrs...)
+}
+
+// DialErrors returns the errors of the tracked dials that failed since the last Reset(),
+// in the order they failed.
+func (t *Transport) DialErrors() []error {
+	t.errMu.Lock()
+	defer t.errMu.Unlock()
+	return append([]error(nil), t.dialErrs...)
+}
+
+// dialFailed records the error of a failed tracked dial.
+func (t *Transport) dialFailed(err error) {
+	t.errMu.Lock()
+	t.dialErrs = append(t.dialErrs, err)
+	t.errMu.Unlock()
+}
+
+// releaseFailed records a failed held-byte write and reports it to OnReleaseError.
+// Caller must hold sc.mu.
+func (t *Transport) releaseFailed(sc *StraddleConn, err error) {
+	sc.err = err
+	sc.noteResult(func(r *ConnResult) {
+		r.Err = err
+	})
+
+	t.errMu.Lock()
+	t.releaseErrs = append(t.releaseErrs, err)
+	t.errMu.Unlock()
+
+	if t.OnReleaseError != nil {
+		t.OnReleaseError(sc, err)
+	}
+}
@@ -0,0 +1,111 @@
+package volley
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptrace"
+	"sync/atomic"
+	"time"
+)
+
+// RequestTiming records when a request passed through each straddling stage.
+// Zero times mean the stage was not reached.
+type RequestTiming struct {
+	// Method and URL identify the request.
+	Method string
+	URL    string
+	// Label is the label of the request context (see WithLabel).
+	Label string
+
+	// Start is when RoundTrip was entered.
+	Start time.Time
+	// HeadersSent is when net/http finished writing the request headers.
+	HeadersSent time.Time
+	// HeldAt is when the connection started holding bytes.
+	HeldAt time.Time
+	// ReleasedAt is when the held bytes were written.
+	ReleasedAt time.Time
+	// Done is when RoundTrip returned.
+	Done time.Time
+
+	// Err is the error returned by RoundTrip, if any.
+	Err error
+}
+
+// RoundTrip implements http.RoundTripper. It delegates to the embedded http.Transport
+// and records the timing of the request (see Timings). It fails with ErrTransportClosed
+// once the transport was closed.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if atomic.LoadInt32(&t.closed) == 1 {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, ErrTransportClosed
+	}
+
+	rec := &RequestTiming{
+		Method: req.Method,
+		URL:    req.URL.String(),
+		Label:  labelFrom(req.Context()),
+		Start:  t.now(),
+	}
+	t.timingMu.Lock()
+	t.timings = append(t.timings, rec)
+	if slot, ok := req.Context().Value(timingSlotKey{}).(**RequestTiming); ok && *slot == nil {
+		*slot = rec
+	}
+	t.timingMu.Unlock()
+
+	// WithClientTrace composes with a trace already present in the request context
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			if sc, ok := info.Conn.(*StraddleConn); ok {
+				sc.mu.Lock()
+				sc.timing = rec
+				sc.mu.Unlock()
+			}
+		},
+		WroteHeaders: func() {
+			t.stampAt(&rec.HeadersSent, t.now())
+		},
+	}
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+
+	resp, err := t.Transport.RoundTrip(req)
+
+	t.timingMu.Lock()
+	rec.Done = t.now()
+	rec.Err = err
+	t.timingMu.Unlock()
+
+	return resp, err
+}
+
+// Timings returns a copy of the timing records of the requests sent since the last Reset(),
+// in the order they entered RoundTrip.
+func (t *Transport) Timings() []RequestTiming {
+	t.timingMu.Lock()
+	defer t.timingMu.Unlock()
+
+	out := make([]RequestTiming, len(t.timings))
+	for i, rec := range t.timings {
+		out[i] = *rec
+	}
+	return out
+}
+
+// stampAt sets *field to at under timingMu.
+func (t *Transport) stampAt(field *time.Time, at time.Time) {
+	t.timingMu.Lock()
+	*field = at
+	t.timingMu.Unlock()
+}
+
+// timingSlotKey is the context key of a slot receiving the timing record of the request.
+type timingSlotKey struct{}
+
+// withTimingSlot returns a copy of ctx whose request stores its timing record in *slot
+// (the first one, if the request is redirected). The slot is written under timingMu.
+func withTimingSlot(ctx context.Context, slot **RequestTiming) context.Context {
+	return context.WithValue(ctx, timingSlotKey{}, slot)
+}
@@ -1,28 +1,200 @@
 package volley
 
 import (
+	"bytes"
 	"context"
 	"crypto/tls"
+	"errors"
 	"fmt"
+	"io"
 	"net"
 	"net/http"
+	"net/url"
+	"runtime"
 	"strings"
 	"sync"
 	"sync/atomic"
+	"syscall"
 	"time"
 )
 
+// ErrProtocolMismatch is returned by the TLS handshake when the server negotiates a protocol
+// other than HTTP/1.1 while HTTP/2 was not enabled (see Transport.OnProtocolMismatch).
+var ErrProtocolMismatch = errors.New("volley: straddling requires HTTP/1.1")
+
 // Transport is a custom http.RoundTripper that implements the "Header Straddling" technique.
-// It holds the last byte of the request body (or header) until Fire() is called.
+// It holds the last byte(s) of the request body (or header) until Fire() is called.
 type Transport struct {
 	// Embedding http.Transport allows users to configure Proxy, TLS, etc.
 	*http.Transport
 
+	// HoldBytes is the number of trailing bytes withheld until Fire() (default 1).
+	// Some servers read request bodies in larger chunks; holding a few bytes
+	// makes the final read straddle more reliably. Negative values are treated as 1.
+	// Zero disables holding, unless HoldStrategy is set: connections pass every write
+	// through and never count as held, for a baseline run on the same transport.
+	// It is read when a connection is established.
+	HoldBytes int
+
+	// HoldStrategy, if set, decides how many bytes of each request are held (see HoldStrategy).
+	// It takes precedence over HoldBytes and StraddlePoint. It is read when a connection is established.
+	HoldStrategy HoldStrategy
+
+	// StraddlePoint selects where requests are split between sent and held bytes (default LastByte).
+	// The other points parse the outgoing stream, so they apply to HTTP/1.1 only.
+	// It is read when a connection is established.
+	StraddlePoint StraddlePoint
+
+	// HandshakeTimeout bounds the TCP connect (proxy tunnel included), and separately the TLS
+	// handshake, of each tracked dial attempt, for http and https targets alike (default 10s).
+	// It prevents a stuck dial from pinning the "inflight" counter, or a stuck handshake from
+	// holding up Wait. A request context with an earlier deadline still cuts it short.
+	// Zero means no extra timeout beyond the dial context.
+	HandshakeTimeout time.Duration
+
+	// Dialer is used to establish the underlying TCP connections, tracked or not
+	// (e.g. to set LocalAddr, KeepAlive or a Control function). If nil, a zero-value
+	// net.Dialer is used. Its Timeout combines with HandshakeTimeout: the earlier deadline wins.
+	Dialer *net.Dialer
+
+	// LocalAddrFunc, if set, returns the local address each dial binds to, e.g. to spread a
+	// batch over the source IPs of a multi-homed host. attempt counts the dials made with
+	// Dialer over the transport's lifetime, from 0, so it can round-robin over a list.
+	// It overrides Dialer.LocalAddr and does not apply to BaseDialer. Returning nil lets
+	// the system pick.
+	LocalAddrFunc func(attempt int) net.Addr
+
+	// DNS selects how dials resolve the target host (default DNSPerDial). The other
+	// policies resolve each host once per batch and dial the cached addresses, which
+	// removes the lookup latency, and its variance, from every dial but the first.
+	// Reset() drops the cache. It applies to the dials made with Dialer (using its
+	// Resolver, if any), not to BaseDialer, which may resolve remotely.
+	DNS DNSPolicy
+
+	// DialAttempts is the number of tries of each tracked dial (default 1: no retry).
+	// A failed connect is retried after DialBackoff, doubled on every further retry. The
+	// retries of a dial count as one dial in flight, so Wait keeps waiting for them, and
+	// only the last error is recorded (see DialErrors). TLS handshakes are not retried.
+	DialAttempts int
+	DialBackoff  time.Duration
+
+	// MaxConcurrentDials, if positive, caps the number of connects (TCP connect plus proxy
+	// tunnel) running at once; the other dials queue until a slot frees up. It keeps a large
+	// batch from exhausting ephemeral ports or tripping SYN-flood protection. Queued dials
+	// count as in flight (see Stats.DialInflight), so Wait still waits for all of them.
+	// The queueing counts against the dial's deadline: the connect timeout
+	// (HandshakeTimeout) includes it, so raise it for large batches.
+	// It is read by the first dial.
+	MaxConcurrentDials int
+
+	// TCPNoDelay sets TCP_NODELAY on the underlying TCP connections (default true).
+	// With Nagle's algorithm on, the held bytes can be delayed until earlier segments are
+	// acknowledged, or coalesced with them, which defeats the last-byte sync. Go enables
+	// TCP_NODELAY by default, but a custom Dialer Control or BaseDialer may not.
+	TCPNoDelay bool
+
+	// TCPCork, on Linux, sets TCP_CORK on the underlying TCP connection while it holds a
+	// request: the bytes sent before the held ones are queued in full segments instead of
+	// one per write. The cork is removed right before the held bytes are written, which
+	// pushes out what is still queued, and the held bytes follow in a write of their own,
+	// so they leave in a separate segment (with TCPNoDelay). Linux sends corked data after
+	// 200ms anyway, so only the bytes of the last writes before the fire wait for it.
+	// It is ignored on other platforms and for non-TCP connections.
+	TCPCork bool
+
+	// BaseDialer, if set, establishes the underlying connections instead of Dialer,
+	// e.g. to bind a source interface or go through a SOCKS5 dialer.
+	// Straddling and tracking apply to whatever it returns; with Proxy set,
+	// it dials the proxy.
+	BaseDialer func(ctx context.Context, network, addr string) (net.Conn, error)
+
+	// TLSHandshaker, if set, replaces tls.Client for the https targets (not for an https
+	// proxy), e.g. to shape the ClientHello with a TLS library of its own. It receives the
+	// raw (or tunneled) conn and the server name, and returns the TLS conn. It may run the
+	// handshake itself, or leave it to a HandshakeContext(context.Context) error method of the
+	// returned conn, which net/http calls right after the dial. TLSClientConfig is not used.
+	//
+	// The returned conn must expose the negotiated ALPN protocol through a
+	// ConnectionState() tls.ConnectionState method: without it, a server choosing "h2"
+	// would get HTTP/1.1 bytes instead of failing with ErrProtocolMismatch. Offer only
+	// "http/1.1" in its ALPN, as browser presets often include "h2".
+	TLSHandshaker func(rawConn net.Conn, serverName string) (net.Conn, error)
+
+	// UnixSocket, if set, is the path of a UNIX domain socket every connection is dialed to,
+	// whatever the host of the request URL, which still sets the Host header and the TLS
+	// server name. Proxy is ignored; straddling and tracking work as over TCP.
+	UnixSocket string
+
+	// Proxy specifies a function to return a proxy for a given request (see http.Transport.Proxy).
+	// The transport opens a tunnel for both http and https targets, with HTTP CONNECT for
+	// http:// and https:// proxies or with SOCKS5 for socks5:// and socks5h:// ones, and
+	// straddles the tunneled stream only, so the proxy handshake is never held.
+	//
+	// It shadows the embedded http.Transport.Proxy, which must stay nil: net/http would
+	// otherwise talk to the proxy itself through the straddled connection.
+	Proxy func(*http.Request) (*url.URL, error)
+
+	// SpinLead, if positive, makes Fire() target an instant SpinLead ahead instead of now:
+	// the releasing goroutines (one per CPU) are started right away and busy-spin until that
+	// instant, so scheduling delays are absorbed before the writes rather than between them.
+	// It burns every CPU for SpinLead on each Fire; a few hundred microseconds is typical.
+	// FireJitter measures the release delays from the target instant.
+	//
+	// The batch counts as fired from the call on, so a request written during the lead is
+	// not held: it waits for the target instant like the held ones, and goes out with them.
+	SpinLead time.Duration
+
+	// FireOrder selects how Fire() releases the held connections (default OrderBroadcast).
+	// The ordered modes write one connection at a time, so the batch is spread wider.
+	FireOrder FireOrder
+
+	// ReadinessProbe, if set, makes the fires skip the held connections the server gave up
+	// on: it sent data (e.g. an error response) or closed the connection while the request
+	// was held. Their held bytes are dropped instead of written, on fire as on Close
+	// (see Transport.Unhealthy). net/http usually closes such a connection itself once the
+	// early response is read, which already takes it out of the batch.
+	//
+	// The probe does not read on its own: net/http already reads every connection from
+	// the dial on, so a read of the probe would race it and could take response bytes away.
+	// It watches the reads net/http makes instead, which consume nothing extra. It is
+	// racy by nature: a server giving up just before the fire, or whose answer is still in
+	// flight, is not seen, and its connection is fired like the others. An interim
+	// "1xx" response (e.g. "100 Continue") does not count.
+	ReadinessProbe bool
+
+	// OnProtocolMismatch, if set, is called after a TLS handshake that negotiated a protocol
+	// other than HTTP/1.1 via ALPN (e.g. "h2" with a custom TLSClientConfig): straddling is
+	// designed for HTTP/1.1, so the held bytes would not line up with requests.
+	// The connection is then used anyway. If nil, such a handshake fails with
+	// ErrProtocolMismatch, unless HTTP/2 was enabled with WithHTTP2.
+	OnProtocolMismatch func(negotiated string)
+
+	// OnReleaseError, if set, is called when writing the held bytes of a connection fails on fire.
+	// It runs synchronously on the releasing goroutine while the connection is locked,
+	// so it must not block nor call Write, Close or Err on sc.
+	OnReleaseError func(sc *StraddleConn, err error)
+
+	// OnAllReleased, if set, is called once a fire (Fire or FireStaggered) is done writing
+	// the held bytes of every connection of the batch, right before it returns, so
+	// time.Now() in it is the instant the whole volley left the client. Failed writes and
+	// connections closed while held count as done. It runs on the firing goroutine.
+	// Partial fires (FireN, FireHost) do not call it.
+	OnAllReleased func()
+
+	// EventBuffer is the capacity of the Events() channel (default 256).
+	// EventsBlock makes a full channel block the emitter instead of dropping the event.
+	// Both are read by the first Events() call.
+	EventBuffer int
+	EventsBlock bool
+
+	// Logger, if set, receives debug lines at key transitions (see Logger).
+	Logger Logger
+
 	// --- Atomic Counters (Aliged at top for 32-bit compatibility) ---
 
 	// dialStartCount tracks the number of dial attempts started.
 	dialStartCount int32
-	// dialInflight tracks the number of dials currently handshaking.
+	// dialInflight tracks the number of dials currently connecting.
 	dialInflight int32
 	// aliveCount tracks the number of successfully established connections.
 	aliveCount int32
@@ -30,6 +202,32 @@ type Transport struct {
 	heldCount int32
 	// fired indicates whether the "Fire" signal has been triggered (0: Holding, 1: Fired).
 	fired int32
+	// releasedCount counts the connections of the batch whose held bytes were written.
+	releasedCount int32
+	// totalDials and totalFires count tracked dials and fires over the transport's
+	// lifetime; Reset() does not clear them.
+	totalDials int32
+	totalFires int32
+	// eventsDropped counts the events dropped on a full Events() channel.
+	eventsDropped int32
+	// localAttempts numbers the dials passed to LocalAddrFunc.
+	localAttempts int32
+	// closed is set by Close (0: open, 1: closed).
+	closed int32
+	// gen is incremented by Reset(). Connections remember the generation they were dialed in
+	// and stop touching the counters once it is stale.
+	gen uint32
+
+	// dns caches the lookups of the current batch (see DNS).
+	dns dnsCache
+
+	// dialSem holds a token per running connect (see MaxConcurrentDials).
+	dialSem     chan struct{}
+	dialSemOnce sync.Once
+
+	// genMu fences counter updates made on behalf of a generation against Reset():
+	// updates hold the read lock, Reset holds the write lock.
+	genMu sync.RWMutex
 
 	// --- Signaling ---
 
@@ -37,40 +235,165 @@ type Transport struct {
 	// We use atomic.Value to allow lock-free replacement during Reset().
 	fireChAtom atomic.Value
 
-	// notifyCh is used to wake up WaitHeldCount when counters change.
-	notifyCh chan struct{}
+	// epochAtom holds a channel (chan struct{}) that is closed on Reset().
+	// Background watchers started by Ready() exit when it closes.
+	epochAtom atomic.Value
+
+	// abandonAtom holds a channel (chan struct{}) per generation, closed when the batch
+	// is dropped without firing: by Reset() before a fire, or by Abort(). Connections
+	// whose dial was still in flight then fail instead of holding for a fire that
+	// never comes.
+	abandonAtom atomic.Value
+
+	// waiters wakes up every active Wait/Ready call when counters change.
+	waiters notifier
+
+	// gateN counts the outstanding gate tokens (see AddToGate); gateCh is closed
+	// when it drops to zero.
+	gateN  int
+	gateCh chan struct{}
+	gateMu sync.Mutex
+
+	// eventsAtom holds the Events() channel (chan Event) once it was requested.
+	eventsAtom atomic.Value
+	eventsOnce sync.Once
+	// eventsMu fences the sends of emit against Close closing the channel; closeCh, closed
+	// by Close, wakes the senders blocked on a full channel (see EventsBlock).
+	eventsMu sync.RWMutex
+	closeCh  chan struct{}
+
+	// --- Registry ---
+
+	// heldConns lists the connections currently holding data, in the order they were armed.
+	// Fire iterates it to release every connection directly, without a goroutine per connection.
+	heldConns []*StraddleConn
+	heldMu    sync.Mutex
+
+	// liveConns is the set of connections not closed yet, across batches. It holds the
+	// underlying conns rather than the StraddleConns, so the finalizer of a StraddleConn
+	// dropped without Close can still run.
+	liveConns map[net.Conn]struct{}
+	liveMu    sync.Mutex
+
+	// clock provides the timers and timestamps; nil means the real clock (see clock).
+	clock clock
+
+	// --- Timing ---
+
+	// firedAt is the instant Fire() broadcast the signal.
+	firedAt time.Time
+	// releaseLags holds, per released connection, the delay between the triggering
+	// Fire/FireN call and the write of its held bytes.
+	releaseLags []time.Duration
+	// fireToWrite holds, per released connection, the delay between the triggering
+	// Fire/FireN call and the return of the write of its held bytes.
+	fireToWrite []time.Duration
+	// releaseTimes holds the instant of each release, in release order.
+	releaseTimes []time.Time
+	// dialDurations holds the duration of each successful tracked dial, TLS handshake included.
+	dialDurations []time.Duration
+	// results holds a record per connection of the batch, in the order they were dialed
+	// (see Results). The connections update their own record through StraddleConn.result.
+	results   []*ConnResult
+	samplesMu sync.Mutex
+
+	// releaseErrs collects the errors of failed held-byte writes.
+	releaseErrs []error
+	// dialErrs collects the errors of failed tracked dials.
+	dialErrs []error
+	errMu    sync.Mutex
+
+	// timings holds a record per request sent through RoundTrip, in arrival order.
+	timings  []*RequestTiming
+	timingMu sync.Mutex
+
+	// warm holds the connections established by Prewarm, keyed by scheme://host:port.
+	warm   map[string][]net.Conn
+	warmMu sync.Mutex
 }
 
 // NewTransport creates a new Transport ready for race condition testing.
-func NewTransport() *Transport {
+// It is a thin wrapper around NewTransportWithOptions.
+func NewTransport(opts ...Option) *Transport {
+	return NewTransportWithOptions(opts...)
+}
+
+// NewTransportWithOptions creates a new Transport and applies opts in order.
+// Without options it uses the defaults documented on each Option: HTTP/1.1 only,
+// keep-alives disabled, certificates verified, 1 held byte and a 10s handshake timeout.
+func NewTransportWithOptions(opts ...Option) *Transport {
 	t := &Transport{
-		notifyCh: make(chan struct{}, 1),
+		HoldBytes:        1,
+		HandshakeTimeout: 10 * time.Second,
+		TCPNoDelay:       true,
+		EventBuffer:      256,
 	}
 
 	// Initialize the broadcast channel
 	t.fireChAtom.Store(make(chan struct{}))
+	t.epochAtom.Store(make(chan struct{}))
+	t.abandonAtom.Store(make(chan struct{}))
+	t.closeCh = make(chan struct{})
 
 	// Helper to track dial state
-	trackDial := func(dialFunc func() (net.Conn, error)) (net.Conn, error) {
+	trackDial := func(ctx context.Context, addr string, dialFunc func() (net.Conn, error)) (net.Conn, error) {
+		t.genMu.RLock()
+		// The connection belongs to the batch in which its dial started
+		gen := atomic.LoadUint32(&t.gen)
+		ch := t.fireChAtom.Load().(chan struct{})
+		abandon := t.abandonAtom.Load().(chan struct{})
 		// 1. Mark attempt started
 		atomic.AddInt32(&t.dialStartCount, 1)
+		atomic.AddInt32(&t.totalDials, 1)
 		// 2. Mark inflight
 		atomic.AddInt32(&t.dialInflight, 1)
+		t.genMu.RUnlock()
+		t.emit(EventDialStarted, "", addr)
+		t.logf("dial start", "addr", addr)
 
-		conn, err := dialFunc()
-
-		// 3. Cleanup inflight status
-		atomic.AddInt32(&t.dialInflight, -1)
+		start := t.now()
+		conn, err := t.retryDial(ctx, addr, dialFunc)
 
+		// 3. Settle the dial (unless Reset already zeroed the counters). The outcome is
+		// recorded before the dial stops counting as inflight: a Wait seeing no dial in
+		// flight must see the error, or the connection alive, along with it.
 		if err != nil {
+			t.logf("dial failed", "addr", addr, "elapsed", t.since(start), "err", err)
+			t.inGen(gen, func() {
+				t.dialFailed(err)
+				atomic.AddInt32(&t.dialInflight, -1)
+			})
 			// Notify waiters that an inflight dial finished (failed)
 			t.tryNotify()
 			return nil, err
 		}
 
+		// The batch was dropped while the dial was in flight: nothing will fire it
+		select {
+		case <-abandon:
+			conn.Close()
+			t.logf("dial abandoned", "addr", addr)
+			t.tryNotify()
+			return nil, ErrBatchAbandoned
+		default:
+		}
+
+		t.logf("dial done", "addr", addr, "elapsed", t.since(start))
+
 		// 4. Wrap successful connection
 		// Notify logic is handled inside wrapConn -> Close
-		return t.wrapConn(conn), nil
+		sc := t.wrapConn(ctx, conn, addr, gen, ch, abandon)
+		t.inGen(gen, func() {
+			atomic.AddInt32(&t.dialInflight, -1)
+		})
+		t.tryNotify()
+		if _, isTLS := conn.(interface{ HandshakeContext(context.Context) error }); isTLS {
+			// The dial completes with the handshake (see HandshakeContext)
+			sc.dialStart = start
+		} else {
+			t.dialDone(gen, t.since(start))
+		}
+		return sc, nil
 	}
 
 	// Initialize underlying http.Transport
@@ -79,305 +402,1747 @@ func NewTransport() *Transport {
 		DisableKeepAlives:   true,  // Critical: Ensure 1 Request = 1 Connection
 		MaxIdleConnsPerHost: -1,    // Disable connection pooling
 
+		// Certificates are verified by default; use WithInsecureSkipVerify(true) for test targets
 		TLSClientConfig: &tls.Config{
-			NextProtos:         []string{"http/1.1"},
-			InsecureSkipVerify: true, // Default to insecure for security testing tools
+			NextProtos: []string{"http/1.1"},
 		},
 
 		DialTLSContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
-			// Fast path: if already fired, bypass all tracking logic for performance
-			if atomic.LoadInt32(&t.fired) == 1 {
-				var d net.Dialer
-				return d.DialContext(ctx, network, addr)
+			if passthrough(ctx) {
+				return t.dialTLS(ctx, network, addr)
 			}
 
-			return trackDial(func() (net.Conn, error) {
-				// We enforce a timeout on the handshake itself to prevent stuck "inflight" counters
-				handshakeCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
-				defer cancel()
-
-				var d net.Dialer
-				rawConn, err := d.DialContext(handshakeCtx, network, addr)
-				if err != nil {
-					return nil, err
+			// Fast path: if already fired, bypass all tracking logic for performance.
+			// Kept-alive connections must be wrapped anyway, since a later batch may reuse them.
+			if atomic.LoadInt32(&t.fired) == 1 && t.DisableKeepAlives {
+				if c := t.takeWarm("https", addr); c != nil {
+					return c, nil
 				}
+				return t.dialTLS(ctx, network, addr)
+			}
 
-				// Standard TLS setup
-				tlsConfig := t.Transport.TLSClientConfig.Clone()
-				if tlsConfig.ServerName == "" {
-					if colon := strings.LastIndex(addr, ":"); colon > 0 {
-						tlsConfig.ServerName = addr[:colon]
-					} else {
-						tlsConfig.ServerName = addr
-					}
+			return trackDial(ctx, addr, func() (net.Conn, error) {
+				if c := t.takeWarm("https", addr); c != nil {
+					return c, nil
 				}
 
-				tlsConn := tls.Client(rawConn, tlsConfig)
-				if err := tlsConn.HandshakeContext(handshakeCtx); err != nil {
-					rawConn.Close()
-					return nil, err
-				}
-				return tlsConn, nil
+				// We enforce a timeout on the connect itself to prevent stuck "inflight" counters
+				connectCtx, cancel := withTimeout(ctx, t.HandshakeTimeout)
+				defer cancel()
+
+				return t.dialTLS(connectCtx, network, addr)
 			})
 		},
 
 		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
-			if atomic.LoadInt32(&t.fired) == 1 {
-				var d net.Dialer
-				return d.DialContext(ctx, network, addr)
+			if passthrough(ctx) {
+				return t.dialTarget(ctx, network, addr, "http")
+			}
+
+			if atomic.LoadInt32(&t.fired) == 1 && t.DisableKeepAlives {
+				if c := t.takeWarm("http", addr); c != nil {
+					return c, nil
+				}
+				return t.dialTarget(ctx, network, addr, "http")
 			}
 
-			return trackDial(func() (net.Conn, error) {
-				var d net.Dialer
-				return d.DialContext(ctx, network, addr)
+			return trackDial(ctx, addr, func() (net.Conn, error) {
+				if c := t.takeWarm("http", addr); c != nil {
+					return c, nil
+				}
+
+				// Same connect timeout as for https: a stuck connect would pin the inflight counter
+				connectCtx, cancel := withTimeout(ctx, t.HandshakeTimeout)
+				defer cancel()
+
+				return t.dialTarget(connectCtx, network, addr, "http")
 			})
 		},
 	}
+
+	for _, opt := range opts {
+		opt(t)
+	}
 	return t
 }
 
+// NewTestTransport creates a Transport whose connections all come from dial, e.g. one end
+// of a net.Pipe, so race logic can be tested without a network. The straddling, fire
+// and counting machinery is unchanged; opts are applied as in NewTransportWithOptions.
+//
+// Use plain http:// URLs: https targets would need a TLS server on the other end.
+//
+//	vt := volley.NewTestTransport(func(ctx context.Context) (net.Conn, error) {
+//		client, server := net.Pipe()
+//		go serve(server) // read the request, write a response
+//		return client, nil
+//	})
+//	client := &http.Client{Transport: vt}
+//	// ... send want requests, vt.Wait(ctx, want), check vt.Stats().Held, vt.Fire()
+func NewTestTransport(dial func(ctx context.Context) (net.Conn, error), opts ...Option) *Transport {
+	base := func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return dial(ctx)
+	}
+	return NewTransportWithOptions(append([]Option{WithBaseDialer(base)}, opts...)...)
+}
+
+// dialTLS connects to addr (through the proxy, if any) and sets up the TLS client.
+//
+// The handshake is left to net/http, which runs it right after the dial through
+// HandshakeContext, between the TLSHandshakeStart and TLSHandshakeDone hooks of
+// httptrace: the trace then reports the real handshake time. (The DNS and connect
+// hooks are called by net.Dialer.) net/http makes that call on a conn other than a
+// *tls.Conn only since Go 1.27, hence the go directive of go.mod.
+func (t *Transport) dialTLS(ctx context.Context, network, addr string) (net.Conn, error) {
+	rawConn, err := t.dialTarget(ctx, network, addr, "https")
+	if err != nil {
+		return nil, err
+	}
+
+	// Standard TLS setup
+	tlsConfig := t.Transport.TLSClientConfig.Clone()
+	if tlsConfig.ServerName == "" {
+		tlsConfig.ServerName = serverName(addr)
+	}
+
+	if t.TLSHandshaker != nil {
+		c, err := t.TLSHandshaker(rawConn, tlsConfig.ServerName)
+		if err != nil {
+			rawConn.Close()
+			return nil, err
+		}
+		return c, nil
+	}
+	return tls.Client(rawConn, tlsConfig), nil
+}
+
+// serverName derives the TLS SNI host from a dial address ("host:port", "[v6]:port" or a bare host).
+func serverName(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	// No port: keep the host, minus the brackets of an IPv6 literal
+	return strings.TrimSuffix(strings.TrimPrefix(addr, "["), "]")
+}
+
+// withTimeout is context.WithTimeout, except that a non-positive d adds no timeout.
+func withTimeout(ctx context.Context, d time.Duration) (context.Context, context.CancelFunc) {
+	if d <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, d)
+}
+
+// retryDial runs dial up to DialAttempts times, doubling the wait from DialBackoff
+// between tries, and returns the first success or the last error.
+func (t *Transport) retryDial(ctx context.Context, addr string, dial func() (net.Conn, error)) (net.Conn, error) {
+	backoff := t.DialBackoff
+	for attempt := 1; ; attempt++ {
+		conn, err := dial()
+		if err == nil || attempt >= t.DialAttempts || ctx.Err() != nil {
+			return conn, err
+		}
+		t.logf("dial retry", "addr", addr, "attempt", attempt, "err", err)
+
+		timer := t.newTimer(backoff)
+		select {
+		case <-timer.C():
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, err
+		}
+		backoff *= 2
+	}
+}
+
+// dialBase opens a raw connection with BaseDialer, Dialer or a zero-value net.Dialer, in that order.
+// The net.Dialer paths resolve the host through the DNS cache (see Transport.DNS).
+func (t *Transport) dialBase(ctx context.Context, network, addr string) (net.Conn, error) {
+	if t.BaseDialer != nil {
+		return t.BaseDialer(ctx, network, addr)
+	}
+	d := t.Dialer
+	if d == nil {
+		d = &net.Dialer{}
+	}
+	if network == "unix" {
+		// A socket path: no source address to pick, no host to resolve
+		return d.DialContext(ctx, network, addr)
+	}
+	if t.LocalAddrFunc != nil {
+		attempt := int(atomic.AddInt32(&t.localAttempts, 1) - 1)
+		dd := *d
+		dd.LocalAddr = t.LocalAddrFunc(attempt)
+		d = &dd
+	}
+
+	resolver := d.Resolver
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+	addr, err := t.dns.resolve(ctx, resolver, t.DNS, addr)
+	if err != nil {
+		return nil, err
+	}
+	return d.DialContext(ctx, network, addr)
+}
+
 // wrapConn encapsulates a net.Conn with straddling logic.
-func (t *Transport) wrapConn(c net.Conn) *StraddleConn {
-	atomic.AddInt32(&t.aliveCount, 1)
+// ctx is the dial context, which carries the values of the request that triggered the dial.
+// addr is the dialed target; gen, ch and abandon are the generation, fire channel and
+// abandon channel of the batch the dial started in.
+func (t *Transport) wrapConn(ctx context.Context, c net.Conn, addr string, gen uint32, ch, abandon chan struct{}) *StraddleConn {
+	t.inGen(gen, func() {
+		atomic.AddInt32(&t.aliveCount, 1)
+	})
 
 	// Notify WaitHeldCount that we have a new alive connection (wait condition might be met)
 	t.tryNotify()
 
-	// Load the current fire channel
-	ch := t.fireChAtom.Load().(chan struct{})
+	sc := &StraddleConn{
+		Conn:      c,
+		owner:     t,
+		holder:    t.newHolder(),
+		fireCh:    ch,
+		abandonCh: abandon,
+		gen:       gen,
+		Label:     labelFrom(ctx),
+		Addr:      addr,
+	}
+	if !sc.holds() {
+		sc.bypass = 1
+	}
+	t.inGen(gen, func() {
+		t.addResult(sc)
+	})
+
+	// Safety net: a conn dropped without Close would keep aliveCount inflated
+	// and Wait's "held == alive" condition could never be met.
+	runtime.SetFinalizer(sc, (*StraddleConn).finalize)
+
+	t.trackLive(c)
 
-	return &StraddleConn{
-		Conn:    c,
-		owner:   t,
-		fireCh:  ch,
-		closeCh: make(chan struct{}),
+	t.connAlive(sc)
+	return sc
+}
+
+// trackLive adds c to the live connections Abort closes.
+func (t *Transport) trackLive(c net.Conn) {
+	t.liveMu.Lock()
+	if t.liveConns == nil {
+		t.liveConns = make(map[net.Conn]struct{})
+	}
+	t.liveConns[c] = struct{}{}
+	t.liveMu.Unlock()
+}
+
+// untrackLive removes c from the live connections.
+func (t *Transport) untrackLive(c net.Conn) {
+	t.liveMu.Lock()
+	delete(t.liveConns, c)
+	t.liveMu.Unlock()
+}
+
+// setNoDelay applies noDelay to the TCP connection under c, if any.
+// Dials call it before the TLS handshake, so even the handshake segments follow the setting.
+func setNoDelay(c net.Conn, noDelay bool) {
+	if tc, ok := tcpConn(c); ok {
+		tc.SetNoDelay(noDelay)
+	}
+}
+
+// tcpConn returns the *net.TCPConn under c, looking through TLS layers
+// (including the TLS connection to an https proxy).
+func tcpConn(c net.Conn) (*net.TCPConn, bool) {
+	for {
+		switch cc := c.(type) {
+		case *net.TCPConn:
+			return cc, true
+		case interface{ NetConn() net.Conn }:
+			c = cc.NetConn()
+		default:
+			return nil, false
+		}
 	}
 }
 
 // Fire releases the last byte for all currently buffered connections.
 // It also sets the transport to "Fired" mode, where subsequent requests pass through immediately.
-func (t *Transport) Fire() {
+// If gate tokens are outstanding (see AddToGate), it first blocks until they are all done.
+//
+// It returns the number of connections whose held bytes were released by this fire.
+// Connections closed while held are not counted, and only the first call of a batch
+// fires: later calls return 0. Once the transport is closed, it fails with ErrTransportClosed.
+func (t *Transport) Fire() (int, error) {
+	if atomic.LoadInt32(&t.closed) == 1 {
+		return 0, ErrTransportClosed
+	}
+	t.waitGate()
+	return t.fire(), nil
+}
+
+// fire is Fire without the gate.
+func (t *Transport) fire() int {
+	trigger := t.now()
+	if t.SpinLead > 0 {
+		trigger = trigger.Add(t.SpinLead)
+	}
+	return t.fireAt(trigger)
+}
+
+// fireAt is fire, with the instant the releases start at (see SpinLead) given by the caller.
+func (t *Transport) fireAt(trigger time.Time) int {
+	// The read lock keeps Reset out until the fired flag, the fire channel and the
+	// batch taken below all belong to the same generation
+	t.genMu.RLock()
 	// CAS ensures we only close the channel once
 	if !atomic.CompareAndSwapInt32(&t.fired, 0, 1) {
-		return
+		t.genMu.RUnlock()
+		return 0
 	}
+	atomic.AddInt32(&t.totalFires, 1)
 
-	// Broadcast signal
-	ch := t.fireChAtom.Load().(chan struct{})
-	close(ch)
-}
+	t.samplesMu.Lock()
+	t.firedAt = trigger
+	t.samplesMu.Unlock()
 
-// Reset clears the transport state, allowing it to be reused for a new batch of requests.
-// NOTE: This must be called serially (not concurrently with Fire or WaitHeldCount).
-func (t *Transport) Reset() {
-	t.fireChAtom.Store(make(chan struct{}))
-	atomic.StoreInt32(&t.heldCount, 0)
-	atomic.StoreInt32(&t.aliveCount, 0)
-	atomic.StoreInt32(&t.dialStartCount, 0)
-	atomic.StoreInt32(&t.dialInflight, 0)
-	atomic.StoreInt32(&t.fired, 0)
+	// Broadcast signal (followed by connections of this batch once it is Reset)
+	close(t.fireChAtom.Load().(chan struct{}))
+
+	// Connections arming from now on see the fired flag in track, so the batch is complete
+	t.heldMu.Lock()
+	batch := t.heldConns
+	t.heldConns = nil
+	t.heldMu.Unlock()
+	// Release outside genMu: release takes it again (see inGen)
+	t.genMu.RUnlock()
+
+	t.logf("fire", "held", len(batch), "trigger", trigger)
+	var released int
+	if t.FireOrder == OrderBroadcast {
+		released = t.releaseBatch(batch, trigger)
+	} else {
+		released = t.releaseOrdered(batch, t.FireOrder, trigger)
+	}
+	if t.OnAllReleased != nil {
+		t.OnAllReleased()
+	}
+	t.emit(EventFired, "", "")
+	return released
 }
 
-// Wait blocks until the connection pool reaches the target state.
-// Return conditions:
-// 1. All initiated dials have finished (success or fail).
-// 2. AND (Held connections >= want OR Held connections == Alive connections).
+// FireStaggered releases the held connections one at a time, in the order they were armed,
+// waiting interval between consecutive releases. It returns the connections in the order
+// they were released (their count is the number released). Like Fire, it switches the
+// transport to "Fired" mode, so a later Fire() is a no-op, and waits for the gate tokens.
 //
-// This logic prevents hanging if some connections fail to establish.
-func (t *Transport) Wait(ctx context.Context, want int) error {
-	if want <= 0 {
+// This trades simultaneity for a controlled spacing, which helps measuring the width of a
+// race window. The k-th release is scheduled k*interval after the call, so the spacing
+// does not drift; the last millisecond before each release is spent yielding instead of
+// sleeping, which keeps sub-millisecond gaps accurate at the cost of CPU.
+func (t *Transport) FireStaggered(interval time.Duration) []*StraddleConn {
+	t.waitGate()
+	// As in fire, Reset waits until the flag, the channel and the batch are taken
+	t.genMu.RLock()
+	if !atomic.CompareAndSwapInt32(&t.fired, 0, 1) {
+		t.genMu.RUnlock()
 		return nil
 	}
+	atomic.AddInt32(&t.totalFires, 1)
+	ch := t.fireChAtom.Load().(chan struct{})
 
-	check := func() bool {
-		start := atomic.LoadInt32(&t.dialStartCount)
-		inflight := atomic.LoadInt32(&t.dialInflight)
-		held := atomic.LoadInt32(&t.heldCount)
-		alive := atomic.LoadInt32(&t.aliveCount)
+	trigger := t.now()
+	t.samplesMu.Lock()
+	t.firedAt = trigger
+	t.samplesMu.Unlock()
 
-		// Condition 1: Wait until all expected goroutines have started dialing
-		if start < int32(want) {
-			return false
-		}
+	t.heldMu.Lock()
+	batch := t.heldConns
+	t.heldConns = nil
+	t.heldMu.Unlock()
+	t.genMu.RUnlock()
 
-		// Condition 2: Wait until all handshakes settle (no ramp-up)
-		if inflight > 0 {
-			return false
+	var order []*StraddleConn
+	for _, sc := range batch {
+		if len(order) > 0 && interval > 0 {
+			t.sleepUntil(trigger.Add(time.Duration(len(order)) * interval))
 		}
-
-		// Condition 3: Success logic
-		// If alive is 0, it means all attempts failed. We should return to let caller handle errors.
-		if alive == 0 {
-			return true
+		if sc.release(trigger) {
+			order = append(order, sc)
 		}
-		// Otherwise, wait until all survivors are buffered/ready.
-		return held == alive
 	}
 
-	// Fast path check
-	if check() {
-		return nil
+	// Broadcast signal (followed by connections of this batch once it is Reset)
+	close(ch)
+	if t.OnAllReleased != nil {
+		t.OnAllReleased()
 	}
+	t.logf("fire staggered", "released", len(order), "interval", interval)
+	t.emit(EventFired, "", "")
+	return order
+}
+
+// sleepUntil waits until deadline on the transport's clock. A timer overshoots by the
+// timer granularity of the OS, so the last millisecond is spent yielding in a loop.
+func (t *Transport) sleepUntil(deadline time.Time) {
+	if d := deadline.Sub(t.now()) - time.Millisecond; d > 0 {
+		timer := t.newTimer(d)
+		<-timer.C()
+	}
+	for t.now().Before(deadline) {
+		runtime.Gosched()
+	}
+}
+
+// spinUntil busy-waits until deadline on the transport's clock, without yielding.
+func (t *Transport) spinUntil(deadline time.Time) {
+	for t.now().Before(deadline) {
+	}
+}
+
+// FireAfter schedules Fire() once d elapses, regardless of how many connections are held.
+// Combined with Wait it gives a "fire when ready OR after deadline" pattern.
+//
+// The timer goroutine exits early if the transport fires first or is Reset().
+func (t *Transport) FireAfter(d time.Duration) {
+	ch := t.fireChAtom.Load().(chan struct{})
+	epoch := t.epochAtom.Load().(chan struct{})
+
+	go func() {
+		timer := t.newTimer(d)
+		defer timer.Stop()
 
-	// Slow path wait
-	for {
 		select {
-		case <-ctx.Done():
-			return fmt.Errorf("%w: timeout. want=%d, start=%d, inflight=%d, alive=%d, held=%d",
-				ctx.Err(), want,
-				atomic.LoadInt32(&t.dialStartCount),
-				atomic.LoadInt32(&t.dialInflight),
-				atomic.LoadInt32(&t.aliveCount),
-				atomic.LoadInt32(&t.heldCount))
-
-		case <-t.notifyCh:
-			if check() {
-				return nil
-			}
+		case <-timer.C():
+			t.Fire()
+		case <-ch:
+			// Already fired
+		case <-epoch:
+			// Reset: the timer belongs to a previous batch
 		}
-	}
+	}()
 }
 
-func (t *Transport) tryNotify() {
-	// Non-blocking send
+// FireOn calls Fire() once trigger is closed (or receives a value), so several transports
+// can fire off one external signal. Like FireAfter, the goroutine exits early if the
+// transport fires first or is Reset().
+func (t *Transport) FireOn(trigger <-chan struct{}) {
+	ch := t.fireChAtom.Load().(chan struct{})
+	epoch := t.epochAtom.Load().(chan struct{})
+
+	go func() {
+		select {
+		case <-trigger:
+			t.Fire()
+		case <-ch:
+			// Already fired
+		case <-epoch:
+			// Reset: the trigger belongs to a previous batch
+		}
+	}()
+}
+
+// FireContext blocks until ctx is done (deadline or cancel), then calls Fire() and returns ctx.Err().
+// It never fires twice: if the batch fired by other means meanwhile (e.g. a direct Fire call),
+// the Fire is a no-op, and if the batch was Reset(), nothing is fired.
+// This synchronizes a volley to an externally managed clock.
+func (t *Transport) FireContext(ctx context.Context) error {
+	epoch := t.epochAtom.Load().(chan struct{})
+
+	<-ctx.Done()
 	select {
-	case t.notifyCh <- struct{}{}:
+	case <-epoch:
+		// Reset: the deadline belonged to a previous batch
 	default:
+		t.Fire()
 	}
+	return ctx.Err()
 }
 
-// --- Straddle Conn ---
+// FireCh returns the broadcast channel of the current batch. It is closed when the
+// batch fires, so it can drive other components off this transport's Fire.
+// Reset() installs a new channel: call FireCh again for the next batch.
+func (t *Transport) FireCh() <-chan struct{} {
+	return t.fireChAtom.Load().(chan struct{})
+}
 
-type StraddleConn struct {
-	net.Conn
-	owner   *Transport
-	fireCh  chan struct{}
-	closeCh chan struct{}
+// FireN releases up to n currently held connections, in the order they were armed,
+// and returns how many were actually released. The remaining connections stay held.
+//
+// Unlike Fire, FireN does not switch the transport to "Fired" mode. A later Fire()
+// releases the rest; connections already released by FireN are not released twice.
+// A connection released by FireN writes its later bytes straight through, as after Fire:
+// it is not armed again within the batch.
+func (t *Transport) FireN(n int) int {
+	if n <= 0 {
+		return 0
+	}
 
-	held      byte
-	hasHeld   bool
-	isCounted bool
+	t.genMu.RLock()
+	if atomic.LoadInt32(&t.fired) == 1 {
+		t.genMu.RUnlock()
+		return 0
+	}
+	t.heldMu.Lock()
+	if n > len(t.heldConns) {
+		n = len(t.heldConns)
+	}
+	batch := make([]*StraddleConn, n)
+	copy(batch, t.heldConns[:n])
+	t.heldConns = t.heldConns[n:]
+	t.heldMu.Unlock()
+	t.genMu.RUnlock()
 
-	// mu protects internal state of this specific connection only.
-	// No global lock contention.
-	mu sync.Mutex
+	// Release outside heldMu: lock order is always sc.mu -> heldMu
+	return t.releaseBatch(batch, t.now())
 }
 
-func (sc *StraddleConn) Write(b []byte) (int, error) {
-	if len(b) == 0 {
-		return 0, nil
+// releaseBatch releases the connections of batch and returns how many were released.
+// The writes are spread over one goroutine per CPU, so a large batch neither
+// serializes on a single thread nor needs a goroutine per connection.
+// If trigger is in the future (see Transport.SpinLead), each goroutine spins until then.
+func (t *Transport) releaseBatch(batch []*StraddleConn, trigger time.Time) int {
+	workers := runtime.GOMAXPROCS(0)
+	if workers > len(batch) {
+		workers = len(batch)
 	}
 
-	// Hot Path: If already fired, bypass lock and buffering
-	if atomic.LoadInt32(&sc.owner.fired) == 1 {
-		return sc.Conn.Write(b)
+	var released int32
+	releasePart := func(part []*StraddleConn) {
+		t.spinUntil(trigger)
+		for _, sc := range part {
+			if sc.release(trigger) {
+				atomic.AddInt32(&released, 1)
+			}
+		}
 	}
 
-	sc.mu.Lock()
-	defer sc.mu.Unlock()
+	if workers <= 1 {
+		releasePart(batch)
+		return int(released)
+	}
 
-	// Double Check
-	if atomic.LoadInt32(&sc.owner.fired) == 1 {
-		if sc.hasHeld {
-			sc.Conn.Write([]byte{sc.held})
-			sc.hasHeld = false
+	var wg sync.WaitGroup
+	chunk := (len(batch) + workers - 1) / workers
+	for i := 0; i < len(batch); i += chunk {
+		end := i + chunk
+		if end > len(batch) {
+			end = len(batch)
 		}
-		return sc.Conn.Write(b)
+		wg.Add(1)
+		go func(part []*StraddleConn) {
+			defer wg.Done()
+			releasePart(part)
+		}(batch[i:end])
 	}
+	wg.Wait()
+	return int(released)
+}
 
-	// Buffer logic
-	var payload []byte
-	if sc.hasHeld {
-		payload = append(payload, sc.held)
-		sc.hasHeld = false
+// FireHost releases the connections currently held for host, in the order they were armed,
+// and returns how many were released. host is either a dial address ("host:port", as
+// reported by HeldByHost) or a bare host name matching every port.
+//
+// Like FireN, it does not switch the transport to "Fired" mode: connections to other
+// hosts stay held.
+func (t *Transport) FireHost(host string) int {
+	// As in FireN, Reset waits until the fired flag and the batch are taken
+	t.genMu.RLock()
+	if atomic.LoadInt32(&t.fired) == 1 {
+		t.genMu.RUnlock()
+		return 0
 	}
-	payload = append(payload, b...)
 
-	if len(payload) == 0 {
-		return len(b), nil
+	t.heldMu.Lock()
+	var batch []*StraddleConn
+	kept := t.heldConns[:0]
+	for _, sc := range t.heldConns {
+		if sc.matchHost(host) {
+			batch = append(batch, sc)
+		} else {
+			kept = append(kept, sc)
+		}
 	}
+	// Clear the tail so the released connections are not retained by the backing array
+	for i := len(kept); i < len(t.heldConns); i++ {
+		t.heldConns[i] = nil
+	}
+	t.heldConns = kept
+	t.heldMu.Unlock()
+	t.genMu.RUnlock()
 
-	// Keep the last byte
-	toHold := payload[len(payload)-1]
-	sc.held = toHold
-	sc.hasHeld = true
+	return t.releaseBatch(batch, t.now())
+}
 
-	// If this is the first time we hold data, increment counters and start listener
-	if !sc.isCounted {
-		atomic.AddInt32(&sc.owner.heldCount, 1)
-		sc.isCounted = true
-		sc.owner.tryNotify()
+// HeldByHost returns the number of held connections per dial address ("host:port").
+func (t *Transport) HeldByHost() map[string]int {
+	t.heldMu.Lock()
+	defer t.heldMu.Unlock()
 
-		// Spawn a lightweight listener for the Fire signal
-		go sc.waitForFire()
+	held := make(map[string]int)
+	for _, sc := range t.heldConns {
+		held[sc.Addr]++
 	}
+	return held
+}
 
-	// Send N-1 bytes
-	toSend := payload[:len(payload)-1]
-	if len(toSend) > 0 {
-		_, err := sc.Conn.Write(toSend)
-		if err != nil {
-			return 0, err
+// WaitHost blocks until at least want connections are held for host (matched as in FireHost).
+// Unlike Wait, it does not account for failed dials: it only returns early when ctx is done.
+func (t *Transport) WaitHost(ctx context.Context, host string, want int) error {
+	if want <= 0 {
+		return nil
+	}
+
+	wake := t.waiters.subscribe()
+	defer t.waiters.unsubscribe(wake)
+
+	for {
+		held := t.heldForHost(host)
+		if held >= want {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%w: timeout. host=%s, want=%d, held=%d", ctx.Err(), host, want, held)
+		case <-wake:
+			t.waiters.ack()
 		}
 	}
+}
 
-	return len(b), nil
+// heldForHost counts the held connections matching host.
+func (t *Transport) heldForHost(host string) int {
+	t.heldMu.Lock()
+	defer t.heldMu.Unlock()
+
+	n := 0
+	for _, sc := range t.heldConns {
+		if sc.matchHost(host) {
+			n++
+		}
+	}
+	return n
 }
 
-func (sc *StraddleConn) waitForFire() {
-	select {
-	case <-sc.fireCh:
-		// Broadcast received
-		sc.release()
-	case <-sc.closeCh:
-		// Connection closed prematurely
-		return
+// track appends a newly armed connection to the registry.
+// It reports false if the transport fired meanwhile: the caller must not hold then,
+// since the fire has already taken (or is taking) its batch from the registry.
+func (t *Transport) track(sc *StraddleConn) bool {
+	t.heldMu.Lock()
+	defer t.heldMu.Unlock()
+	if atomic.LoadInt32(&t.fired) == 1 {
+		return false
 	}
+	t.heldConns = append(t.heldConns, sc)
+	return true
 }
 
-func (sc *StraddleConn) release() {
-	sc.mu.Lock()
-	defer sc.mu.Unlock()
-	if sc.hasHeld {
-		_, _ = sc.Conn.Write([]byte{sc.held})
-		sc.hasHeld = false
+// untrack removes a connection from the registry if it is still present.
+func (t *Transport) untrack(sc *StraddleConn) {
+	t.heldMu.Lock()
+	for i, c := range t.heldConns {
+		if c == sc {
+			t.heldConns = append(t.heldConns[:i], t.heldConns[i+1:]...)
+			break
+		}
 	}
+	t.heldMu.Unlock()
 }
 
-func (sc *StraddleConn) Close() error {
-	sc.mu.Lock()
+// Reset clears the transport state, allowing it to be reused for a new batch of requests.
+//
+// Connections of the previous batch that are still open are fenced off: they no longer
+// affect the counters and keep following the previous batch's Fire signal.
+// If the batch was never fired, connections still holding data are closed without
+// sending their held bytes, so their requests fail instead of hanging; connections
+// whose dial was in flight fail with ErrBatchAbandoned instead of holding.
+//
+// Reset and the fire methods exclude each other: a concurrent Fire fires either the
+// batch being reset or the new one, never a mix of both, and the fire channel of a
+// batch is closed at most once.
+func (t *Transport) Reset() {
+	t.reset(false)
+}
 
-	// If the connection was counted as "Held", we need to reverse that
-	// if it closes before firing.
-	if sc.isCounted {
-		// Only decrement if we haven't fired yet.
-		// If we fired, the counter is conceptually "consumed".
-		if atomic.LoadInt32(&sc.owner.fired) == 0 {
-			atomic.AddInt32(&sc.owner.heldCount, -1)
-		}
-		sc.isCounted = false
+// reset is Reset; abandon drops the batch even if it fired (see Abort).
+func (t *Transport) reset(abandon bool) {
+	t.genMu.Lock()
+	atomic.AddUint32(&t.gen, 1)
 
-		// Signal the background goroutine to stop waiting
+	// Stragglers of a batch that will never fire must not hold (see arm)
+	if abandon || atomic.LoadInt32(&t.fired) == 0 {
+		close(t.abandonAtom.Load().(chan struct{}))
+	}
+	t.abandonAtom.Store(make(chan struct{}))
+
+	// Invalidate watchers started by Ready() for the previous batch
+	close(t.epochAtom.Load().(chan struct{}))
+	t.epochAtom.Store(make(chan struct{}))
+
+	t.fireChAtom.Store(make(chan struct{}))
+	atomic.StoreInt32(&t.heldCount, 0)
+	atomic.StoreInt32(&t.aliveCount, 0)
+	atomic.StoreInt32(&t.dialStartCount, 0)
+	atomic.StoreInt32(&t.dialInflight, 0)
+	atomic.StoreInt32(&t.fired, 0)
+	atomic.StoreInt32(&t.releasedCount, 0)
+
+	t.heldMu.Lock()
+	leftover := t.heldConns
+	t.heldConns = nil
+	t.heldMu.Unlock()
+
+	t.samplesMu.Lock()
+	t.firedAt = time.Time{}
+	t.releaseLags = nil
+	t.fireToWrite = nil
+	t.releaseTimes = nil
+	t.dialDurations = nil
+	t.results = nil
+	t.samplesMu.Unlock()
+
+	t.errMu.Lock()
+	t.releaseErrs = nil
+	t.dialErrs = nil
+	t.errMu.Unlock()
+
+	t.timingMu.Lock()
+	t.timings = nil
+	t.timingMu.Unlock()
+
+	t.dns.reset()
+	t.genMu.Unlock()
+
+	// Connections still held by a batch that never fired would hang forever
+	for _, sc := range leftover {
+		sc.abandon()
+	}
+}
+
+// ErrBatchAbandoned is returned for a request whose connection belongs to a batch that
+// was dropped before it could hold: Reset() before the batch fired, or Abort() or Close().
+var ErrBatchAbandoned = errors.New("volley: batch reset or aborted before the connection held")
+
+// ErrTransportClosed is returned by RoundTrip and Fire once the Transport was closed (see Transport.Close).
+var ErrTransportClosed = errors.New("volley: transport closed")
+
+// ErrKeepAliveDisabled is returned by Rearm when keep-alives are disabled.
+var ErrKeepAliveDisabled = errors.New("volley: keep-alives are disabled")
+
+// Rearm starts a new batch on the warm connections of the previous one: it is Reset,
+// for a transport created with WithKeepAlive(true). Reset never closes a fired
+// connection, and with keep-alives net/http returns it to its idle pool once the response
+// is read, so the next request reuses it (without a new handshake) and holds again.
+//
+// With keep-alives disabled (the default), net/http closes every connection after its
+// response, so there is nothing to rearm: Rearm returns ErrKeepAliveDisabled and leaves
+// the transport untouched. Call Reset to start a new batch on new connections.
+func (t *Transport) Rearm() error {
+	if t.DisableKeepAlives {
+		return ErrKeepAliveDisabled
+	}
+	t.Reset()
+	return nil
+}
+
+// Abort closes every live connection without sending its held bytes, so the servers
+// never receive the complete requests, then resets the transport (see Reset): the
+// counters drop to zero. The warm connections left by Prewarm are closed too. It returns
+// the joined errors of the closes.
+//
+// Dials still in flight are not interrupted, but their connections belong to the
+// aborted batch: they are closed as soon as the dial completes, and their requests
+// fail with ErrBatchAbandoned.
+func (t *Transport) Abort() error {
+	t.liveMu.Lock()
+	live := t.liveConns
+	t.liveConns = nil
+	t.liveMu.Unlock()
+	// The warm connections are live ones: drop the pool, they are closed below
+	t.warmMu.Lock()
+	t.warm = nil
+	t.warmMu.Unlock()
+
+	// Reset first: the connections then belong to a stale batch, so closing them
+	// touches no counter. Reset also drops the held bytes of the current batch.
+	t.reset(true)
+
+	// Closing the underlying conns sends nothing; net/http then closes
+	// the StraddleConns when their requests fail.
+	var errs []error
+	for c := range live {
+		if err := c.Close(); err != nil && !errors.Is(err, net.ErrClosed) {
+			errs = append(errs, err)
+		}
+	}
+	t.logf("abort", "closed", len(live))
+	return errors.Join(errs...)
+}
+
+// Close shuts the transport down: it closes the held connections without sending their
+// held bytes, as Abort does, so the servers never receive those requests, and their dials
+// still in flight fail with ErrBatchAbandoned. It also ends the goroutines started by
+// Ready, FireAfter and FireOn (as Reset does), and closes the idle and prewarmed
+// connections and the Events() channel. Connections already released close once their
+// response is read. To complete the held requests instead, Fire before closing.
+//
+// The transport is unusable afterwards: RoundTrip and Fire return ErrTransportClosed and
+// the other fire methods release nothing. Close is idempotent.
+func (t *Transport) Close() error {
+	if !atomic.CompareAndSwapInt32(&t.closed, 0, 1) {
+		return nil
+	}
+	t.reset(true)
+	// A closed transport stays fired, so a later Fire is a no-op
+	atomic.StoreInt32(&t.fired, 1)
+	t.CloseIdleConnections()
+
+	close(t.closeCh)
+	t.Events()
+	ch := t.eventsAtom.Load().(chan Event)
+	t.eventsMu.Lock()
+	close(ch)
+	t.eventsMu.Unlock()
+
+	t.logf("transport closed")
+	return nil
+}
+
+// Wait blocks until the connection pool reaches the target state.
+// Return conditions:
+// 1. All initiated dials have finished (success or fail).
+// 2. AND (Held connections >= want OR Held connections == Alive connections).
+//
+// This logic prevents hanging if some connections fail to establish.
+// If no connection is alive at that point, Wait returns the dial errors joined
+// together (see DialErrors), so "nobody connected" is not mistaken for success.
+func (t *Transport) Wait(ctx context.Context, want int) error {
+	if want <= 0 {
+		return nil
+	}
+
+	// Subscribe before the first check so no transition can slip in between
+	wake := t.waiters.subscribe()
+	defer t.waiters.unsubscribe(wake)
+
+	// Fast path check
+	if t.checkArmed(want) {
+		return t.armedErr()
+	}
+
+	// Slow path wait
+	for {
+		select {
+		case <-ctx.Done():
+			return t.timeoutErr(ctx.Err(), want)
+
+		case <-wake:
+			t.waiters.ack()
+			if t.checkArmed(want) {
+				return t.armedErr()
+			}
+		}
+	}
+}
+
+// WaitMin blocks until at least min connections are held and no dial is in flight,
+// regardless of how many connections are still alive but not held yet.
+// It suits over-dialing: launch more requests than needed and fire once enough are armed.
+func (t *Transport) WaitMin(ctx context.Context, min int) error {
+	if min <= 0 {
+		return nil
+	}
+
+	wake := t.waiters.subscribe()
+	defer t.waiters.unsubscribe(wake)
+
+	for {
+		if atomic.LoadInt32(&t.heldCount) >= int32(min) && atomic.LoadInt32(&t.dialInflight) == 0 {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return t.timeoutErr(ctx.Err(), min)
+		case <-wake:
+			t.waiters.ack()
+		}
+	}
+}
+
+// WaitDialsSettled blocks until want dials have started and none is still in flight,
+// i.e. the first half of Wait's condition. It returns the joined dial errors if no
+// connection is alive at that point, like Wait. Connections may not be held yet.
+func (t *Transport) WaitDialsSettled(ctx context.Context, want int) error {
+	if want <= 0 {
+		return nil
+	}
+
+	wake := t.waiters.subscribe()
+	defer t.waiters.unsubscribe(wake)
+
+	for {
+		if atomic.LoadInt32(&t.dialStartCount) >= int32(want) && atomic.LoadInt32(&t.dialInflight) == 0 {
+			return t.armedErr()
+		}
+		select {
+		case <-ctx.Done():
+			return t.timeoutErr(ctx.Err(), want)
+		case <-wake:
+			t.waiters.ack()
+		}
+	}
+}
+
+// WaitHeld blocks until want connections are held, or until every alive connection is held
+// once the dials have settled (so failed dials cannot stall it). Together with
+// WaitDialsSettled it splits Wait in two, so each phase gets its own timeout.
+func (t *Transport) WaitHeld(ctx context.Context, want int) error {
+	if want <= 0 {
+		return nil
+	}
+
+	wake := t.waiters.subscribe()
+	defer t.waiters.unsubscribe(wake)
+
+	for {
+		if atomic.LoadInt32(&t.heldCount) >= int32(want) || t.armed(want) {
+			return t.armedErr()
+		}
 		select {
-		case <-sc.closeCh:
+		case <-ctx.Done():
+			return t.timeoutErr(ctx.Err(), want)
+		case <-wake:
+			t.waiters.ack()
+		}
+	}
+}
+
+// timeoutErr wraps the context error of a wait with a snapshot of the counters.
+func (t *Transport) timeoutErr(err error, want int) error {
+	return fmt.Errorf("%w: timeout. want=%d, start=%d, inflight=%d, alive=%d, held=%d",
+		err, want,
+		atomic.LoadInt32(&t.dialStartCount),
+		atomic.LoadInt32(&t.dialInflight),
+		atomic.LoadInt32(&t.aliveCount),
+		atomic.LoadInt32(&t.heldCount))
+}
+
+// armedErr returns the error Wait reports once armed: the joined dial errors
+// if no connection is alive, nil otherwise.
+func (t *Transport) armedErr() error {
+	if atomic.LoadInt32(&t.aliveCount) > 0 {
+		return nil
+	}
+	if err := errors.Join(t.DialErrors()...); err != nil {
+		return fmt.Errorf("volley: no connection established: %w", err)
+	}
+	return nil
+}
+
+// WaitAndFire waits until the pool is armed (see Wait) and then fires immediately.
+// If Wait returns an error, nothing is fired and the error is returned.
+//
+// It is a thin wrapper: callers that need to act between the two steps can still
+// call Wait and Fire separately.
+func (t *Transport) WaitAndFire(ctx context.Context, want int) error {
+	if err := t.Wait(ctx, want); err != nil {
+		return err
+	}
+	t.Fire()
+	return nil
+}
+
+// Ready returns a channel that is closed once the pool reaches the state Wait(ctx, want) blocks for.
+// It is meant to be used in a select alongside other events.
+//
+// Every call returns an independent channel backed by its own watcher goroutine.
+// A subsequent Reset() abandons the watcher: the returned channel is then never closed.
+func (t *Transport) Ready(want int) <-chan struct{} {
+	ready := make(chan struct{})
+	if want <= 0 {
+		close(ready)
+		return ready
+	}
+
+	epoch := t.epochAtom.Load().(chan struct{})
+	wake := t.waiters.subscribe()
+
+	go func() {
+		defer t.waiters.unsubscribe(wake)
+		for {
+			// Check the epoch first so a Reset() always wins over the new batch's counters
+			select {
+			case <-epoch:
+				return
+			default:
+			}
+
+			if t.armed(want) {
+				close(ready)
+				return
+			}
+
+			select {
+			case <-epoch:
+				return
+			case <-wake:
+				t.waiters.ack()
+			}
+		}
+	}()
+
+	return ready
+}
+
+// inGen runs fn if gen is still the current generation and reports whether it ran.
+// fn runs under the generation read lock, so it never races with Reset().
+func (t *Transport) inGen(gen uint32, fn func()) bool {
+	t.genMu.RLock()
+	defer t.genMu.RUnlock()
+	if atomic.LoadUint32(&t.gen) != gen {
+		return false
+	}
+	fn()
+	return true
+}
+
+// armed reports whether the pool has reached the state Wait and Ready block for.
+func (t *Transport) armed(want int) bool {
+	start, inflight, held, alive := t.counters()
+
+	// Condition 1: Wait until all expected goroutines have started dialing
+	if start < int32(want) {
+		return false
+	}
+
+	// Condition 2: Wait until all handshakes settle (no ramp-up)
+	if inflight > 0 {
+		return false
+	}
+
+	// Condition 3: Success logic
+	// If alive is 0, it means all attempts failed. We should return to let caller handle errors.
+	if alive == 0 {
+		return true
+	}
+	// Otherwise, wait until all survivors are buffered/ready.
+	return held == alive
+}
+
+// counters returns a consistent snapshot of the dial and connection counters, reading
+// them until two passes agree: a single pass could pair a held count from before a Close
+// with an alive count from after it. inflight is read before alive, since a dial counts
+// as alive before it stops counting as inflight (see trackDial).
+func (t *Transport) counters() (start, inflight, held, alive int32) {
+	for {
+		start = atomic.LoadInt32(&t.dialStartCount)
+		inflight = atomic.LoadInt32(&t.dialInflight)
+		held = atomic.LoadInt32(&t.heldCount)
+		alive = atomic.LoadInt32(&t.aliveCount)
+		if start == atomic.LoadInt32(&t.dialStartCount) &&
+			inflight == atomic.LoadInt32(&t.dialInflight) &&
+			held == atomic.LoadInt32(&t.heldCount) &&
+			alive == atomic.LoadInt32(&t.aliveCount) {
+			return
+		}
+	}
+}
+
+// checkArmed is armed, logging the counters it checked.
+func (t *Transport) checkArmed(want int) bool {
+	ok := t.armed(want)
+	if t.Logger == nil {
+		return ok
+	}
+	t.logf("wait check",
+		"want", want,
+		"start", atomic.LoadInt32(&t.dialStartCount),
+		"inflight", atomic.LoadInt32(&t.dialInflight),
+		"alive", atomic.LoadInt32(&t.aliveCount),
+		"held", atomic.LoadInt32(&t.heldCount),
+		"armed", ok)
+	return ok
+}
+
+func (t *Transport) tryNotify() {
+	t.waiters.broadcast()
+}
+
+// notifier fans out state-change wakeups to every subscribed waiter.
+// Each waiter channel has a buffer of 1 so notifications coalesce per waiter
+// without stealing wakeups from other waiters. The zero value is ready to use.
+type notifier struct {
+	mu      sync.Mutex
+	waiters map[chan struct{}]struct{}
+}
+
+// subscribe registers a wakeup channel that receives a (coalesced) signal on every state change.
+func (n *notifier) subscribe() chan struct{} {
+	ch := make(chan struct{}, 1)
+	n.mu.Lock()
+	if n.waiters == nil {
+		n.waiters = make(map[chan struct{}]struct{})
+	}
+	n.waiters[ch] = struct{}{}
+	n.mu.Unlock()
+	return ch
+}
+
+func (n *notifier) unsubscribe(ch chan struct{}) {
+	n.mu.Lock()
+	delete(n.waiters, ch)
+	n.mu.Unlock()
+}
+
+// ack must be called after receiving a wakeup, before re-reading the state. A broadcast
+// that finds the channel full drops its signal, and that failed send does not order its
+// state change before the waiter's reads; taking mu does, so the pending signal being
+// consumed always covers the state changes of the dropped ones.
+func (n *notifier) ack() {
+	n.mu.Lock()
+	n.mu.Unlock()
+}
+
+func (n *notifier) broadcast() {
+	n.mu.Lock()
+	for ch := range n.waiters {
+		// Non-blocking send
+		select {
+		case ch <- struct{}{}:
 		default:
-			close(sc.closeCh)
 		}
+	}
+	n.mu.Unlock()
+}
+
+// --- Straddle Conn ---
+
+type StraddleConn struct {
+	net.Conn
+
+	// Label identifies the request that dialed this connection (see WithLabel).
+	Label string
+	// Addr is the target address ("host:port") this connection was dialed to.
+	Addr string
+
+	// owner is the transport that dialed this connection, nil for a standalone
+	// connection (see NewStraddleConn), which only has its holder.
+	owner *Transport
+	// fireCh is the broadcast channel of the batch this connection was dialed in.
+	fireCh <-chan struct{}
+	// abandonCh is the abandon channel of that batch (see Transport.abandonAtom).
+	abandonCh chan struct{}
+
+	// holder holds the bytes of the current request until the release.
+	holder
+	isCounted bool
+	// bypass is set (to 1, under mu) once the connection was released by the fire of
+	// its batch, or from the start when holding is disabled: from then on Write skips mu.
+	// It is read atomically.
+	bypass int32
+	// closed marks a connection whose Close already settled the counters.
+	closed bool
+	// corked marks a connection with TCP_CORK set while it holds (see Transport.TCPCork).
+	corked bool
+	// gen is the Transport generation this connection was dialed in (or rejoined, see WithKeepAlive).
+	// gen, fireCh and abandonCh only change in Write, which net/http never calls concurrently.
+	gen uint32
+	// timing is the record of the request using this connection, set by RoundTrip.
+	timing *RequestTiming
+	// unhealthy is set (to 1) when the server sent data or closed the connection while it
+	// held a request of the current batch (see Transport.ReadinessProbe). It is read atomically.
+	unhealthy int32
+	// result is the record of this connection in its batch (see Transport.Results),
+	// nil if it was dialed for a batch Reset since. It is updated under samplesMu.
+	result *ConnResult
+	// err is the error of the failed write of the held bytes, if any.
+	err error
+	// releasedAt is when the held bytes were written.
+	releasedAt time.Time
+	// dialStart is when the tracked dial of this TLS connection started; the dial
+	// duration is recorded once the handshake completes.
+	dialStart time.Time
+	// stop is closed by Close for a standalone connection (see NewStraddleConn).
+	stop chan struct{}
+
+	// mu protects internal state of this specific connection only.
+	// No global lock contention.
+	mu sync.Mutex
+}
+
+func (sc *StraddleConn) Write(b []byte) (int, error) {
+	if len(b) == 0 {
+		return 0, nil
+	}
+
+	// Hot Path: once released by the fire, bypass lock and buffering. The fire flag alone
+	// is not enough: the held bytes of this conn may not be written yet.
+	if atomic.LoadInt32(&sc.bypass) == 1 && !sc.reusable() {
+		return sc.Conn.Write(b)
+	}
+
+	// Report transitions once sc.mu is released (deferred calls run in reverse order)
+	var rejoined, armed bool
+	defer func() {
+		if rejoined {
+			sc.owner.connAlive(sc)
+		}
+		if armed && sc.owner != nil {
+			sc.owner.connHeld(sc)
+		}
+	}()
+
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	if sc.reusable() {
+		sc.rejoin()
+		rejoined = true
+	}
+	if !sc.holds() {
+		return sc.Conn.Write(b)
+	}
+
+	// Double Check: the fire landed, but the release of this conn may not have run yet.
+	// Whichever of Write and release takes sc.mu first writes the held bytes, so they
+	// go out exactly once and ahead of b.
+	if sc.fired() || sc.released {
+		if !sc.released {
+			if err := sc.releaseLocked(sc.fireTime()); err != nil {
+				return 0, err
+			}
+		} else if sc.fired() {
+			// Released early by FireN
+			atomic.StoreInt32(&sc.bypass, 1)
+		}
+		return sc.Conn.Write(b)
+	}
+
+	// A request expecting "100 Continue" waits for it before writing its body: holding
+	// any of its header block would stall it. Let the headers through, arm on the body.
+	if !sc.isCounted && sc.awaitsContinue(b) {
+		if sc.point != LastByte {
+			// The straddle point is the end of the headers just sent: hold the whole body
+			sc.pointFound = true
+		}
+		n, err := sc.Conn.Write(b)
+		sc.sent += n
+		return n, err
+	}
+
+	// The first Write arms the connection
+	if !sc.isCounted {
+		ok, err := sc.arm()
+		if err != nil {
+			return 0, err
+		}
+		if !ok {
+			// Fire landed since the check above: nothing is held yet
+			sc.releaseLocked(sc.fireTime())
+			return sc.Conn.Write(b)
+		}
+		armed = true
+	}
+
+	// Send everything except the held tail
+	toSend, prev := sc.hold(b)
+	if len(toSend) > 0 {
+		m, err := sc.Conn.Write(toSend)
+		if err == nil && m < len(toSend) {
+			err = io.ErrShortWrite
+		}
+		if err != nil {
+			// The request is cut short: its held bytes will never be sent either,
+			// so only the bytes of b that reached the conn count as written.
+			sc.held = nil
+			return writtenOf(m, prev), err
+		}
+		sc.sent += m
+	}
+
+	return len(b), nil
+}
+
+// Read reads from the underlying connection. Data or an error coming while the connection
+// holds a request marks it unhealthy (see Transport.ReadinessProbe).
+func (sc *StraddleConn) Read(b []byte) (int, error) {
+	n, err := sc.Conn.Read(b)
+	if sc.owner != nil && atomic.LoadInt32(&sc.bypass) == 0 && (n > 0 || err != nil) {
+		sc.readWhileHolding(b[:n], err)
+	}
+	return n, err
+}
+
+// readWhileHolding marks the connection unhealthy if it holds a request of a batch not
+// fired yet: the server answered or closed it before getting the complete request.
+// b and err are the outcome of the read.
+func (sc *StraddleConn) readWhileHolding(b []byte, err error) {
+	if err == nil && interimResponse(b) {
+		return
+	}
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		// A read deadline expired: the server did not answer
+		return
+	}
+
+	sc.mu.Lock()
+	if !sc.isCounted || sc.released || sc.closed || sc.fired() {
+		sc.mu.Unlock()
+		return
+	}
+	if atomic.SwapInt32(&sc.unhealthy, 1) == 1 {
+		sc.mu.Unlock()
+		return
+	}
+	sc.noteResult(func(r *ConnResult) {
+		r.Unhealthy = true
+	})
+	sc.mu.Unlock()
+	sc.owner.logf("conn unhealthy", "addr", sc.Addr, "label", sc.Label, "err", err)
+}
+
+// interimResponse reports whether b starts with the status line of an interim (1xx)
+// response, e.g. "100 Continue" to a request held on its body.
+func interimResponse(b []byte) bool {
+	const proto = "HTTP/1.x "
+	return len(b) > len(proto) && bytes.HasPrefix(b, []byte("HTTP/1.")) && b[len(proto)] == '1'
+}
+
+// writtenOf maps the m bytes of a payload accepted by the underlying conn to the bytes
+// of the current write: the first prev bytes of the payload were held from earlier writes.
+func writtenOf(m, prev int) int {
+	if m < prev {
+		return 0
+	}
+	return m - prev
+}
+
+// fired reports whether the batch this connection belongs to has been fired.
+func (sc *StraddleConn) fired() bool {
+	if sc.owner != nil && atomic.LoadUint32(&sc.owner.gen) == sc.gen {
+		return atomic.LoadInt32(&sc.owner.fired) == 1
+	}
+	// Stale or standalone connection: follow the batch's signal
+	select {
+	case <-sc.fireCh:
+		return true
+	default:
+		return false
+	}
+}
+
+// fireTime returns the instant the batch of the connection fired, or the current time
+// for a standalone connection.
+func (sc *StraddleConn) fireTime() time.Time {
+	if sc.owner == nil {
+		return time.Now()
+	}
+	return sc.owner.fireTime()
+}
+
+// reusable reports whether a kept-alive connection of a previous generation, done with its
+// held request, may join the current batch: a new request is starting on it.
+func (sc *StraddleConn) reusable() bool {
+	if sc.owner == nil || sc.owner.DisableKeepAlives || atomic.LoadUint32(&sc.owner.gen) == sc.gen {
+		return false
+	}
+	return sc.released || !sc.isCounted
+}
+
+// rejoin moves the connection to the current generation, as if it had just been dialed.
+// Caller must hold sc.mu.
+func (sc *StraddleConn) rejoin() {
+	t := sc.owner
+	t.genMu.RLock()
+	sc.gen = atomic.LoadUint32(&t.gen)
+	sc.fireCh = t.fireChAtom.Load().(chan struct{})
+	sc.abandonCh = t.abandonAtom.Load().(chan struct{})
+	atomic.AddInt32(&t.dialStartCount, 1)
+	atomic.AddInt32(&t.aliveCount, 1)
+	t.addResult(sc)
+	t.genMu.RUnlock()
+
+	sc.released = false
+	if sc.holds() {
+		atomic.StoreInt32(&sc.bypass, 0)
+	}
+	sc.releasedAt = time.Time{}
+	sc.err = nil
+	sc.isCounted = false
+	sc.pointFound = false
+	sc.sent = 0
+	atomic.StoreInt32(&sc.unhealthy, 0)
+	t.tryNotify()
+}
+
+// matchHost reports whether the connection was dialed to host ("host:port" or a bare host name).
+func (sc *StraddleConn) matchHost(host string) bool {
+	return sc.Addr == host || serverName(sc.Addr) == host
+}
+
+// arm registers the connection as held and reports whether it may hold data.
+// It returns false if the transport fired in the meantime. Caller must hold sc.mu.
+//
+// Connections of a previous generation are not registered. Their batch either fired,
+// and they write through, or was dropped by Reset or Abort, and arm returns
+// ErrBatchAbandoned: no fire would ever release them. A standalone connection only
+// marks itself held.
+func (sc *StraddleConn) arm() (bool, error) {
+	if sc.owner == nil {
+		sc.isCounted = true
+		return true, nil
+	}
+
+	ok := true
+	current := sc.owner.inGen(sc.gen, func() {
+		if ok = sc.owner.track(sc); ok {
+			atomic.AddInt32(&sc.owner.heldCount, 1)
+		}
+	})
+	if !current {
+		// Reset closed the abandon channel before the generation went stale
+		select {
+		case <-sc.abandonCh:
+			return false, ErrBatchAbandoned
+		default:
+			return false, nil
+		}
+	}
+	if !ok {
+		return false, nil
+	}
+
+	sc.isCounted = true
+	if sc.owner.TCPCork {
+		setCork(sc.Conn, true)
+		sc.corked = true
+	}
+	heldAt := sc.owner.now()
+	sc.stamp(func(r *RequestTiming) *time.Time { return &r.HeldAt }, heldAt)
+	sc.noteResult(func(r *ConnResult) {
+		r.Held = true
+		r.HeldAt = heldAt
+	})
+	sc.owner.tryNotify()
+	return true, nil
+}
+
+// release flushes the held bytes at most once and reports whether they went out,
+// by this call or by a Write that came first.
+// trigger is the instant of the Fire/FireN call that caused the release.
+// A connection closed in the meantime is skipped: a fire may have taken it from
+// the registry just before Close untracked it.
+func (sc *StraddleConn) release(trigger time.Time) bool {
+	// Log once sc.mu is released (deferred calls run in reverse order)
+	var skip bool
+	defer func() {
+		if skip {
+			sc.owner.logf("conn skipped", "addr", sc.Addr, "label", sc.Label)
+		}
+	}()
+
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if sc.released {
+		// A Write since the fire flushed the held bytes first (see Write):
+		// it still counts as released by this fire.
+		return sc.err == nil
+	}
+	if sc.closed || len(sc.held) == 0 {
+		return false
+	}
+
+	var err error
+	skip = sc.owner != nil && sc.owner.ReadinessProbe && atomic.LoadInt32(&sc.unhealthy) == 1
+	if skip {
+		// The server gave up on the request: drop it rather than complete it
+		sc.held = nil
+		sc.released = true
+		sc.uncork()
+	} else {
+		err = sc.releaseLocked(trigger)
+	}
+
+	// A partial release (FireN) happens while the transport is still holding,
+	// so the connection no longer counts as held.
+	if sc.isCounted && !sc.fired() && sc.owner != nil {
+		sc.owner.inGen(sc.gen, func() {
+			atomic.AddInt32(&sc.owner.heldCount, -1)
+		})
+		sc.isCounted = false
+		sc.owner.tryNotify()
+	}
+	return !skip && err == nil
+}
+
+// releaseLocked writes the held bytes and marks the connection released. It is the only
+// place the held bytes are flushed on fire, for both release and Write. Once the batch
+// has fired, later writes take the hot path. Caller must hold sc.mu and check released.
+func (sc *StraddleConn) releaseLocked(trigger time.Time) error {
+	if sc.owner != nil {
+		// A Write landing during the lead of a spin fire waits for the trigger too
+		sc.owner.spinUntil(trigger)
+	}
+	sc.released = true
+	if sc.fired() {
+		defer atomic.StoreInt32(&sc.bypass, 1)
+	}
+	sc.uncork()
+	if len(sc.held) == 0 {
+		return nil
+	}
+
+	t := sc.owner
+	if t == nil {
+		at := time.Now()
+		if _, err := sc.flushHeld(); err != nil {
+			sc.err = err
+			return err
+		}
+		sc.releasedAt = at
+		return nil
+	}
+
+	at := t.now()
+	if _, err := sc.flushHeld(); err != nil {
+		t.releaseFailed(sc, err)
+		return err
+	}
+	t.recordRelease(sc, at, t.now(), trigger)
+	sc.noteResult(func(r *ConnResult) {
+		r.Released = true
+		r.ReleasedAt = at
+	})
+	t.inGen(sc.gen, func() {
+		atomic.AddInt32(&t.releasedCount, 1)
+	})
+	sc.stamp(func(r *RequestTiming) *time.Time { return &r.ReleasedAt }, at)
+	return nil
+}
+
+// ReleasedAt returns when the held bytes of the connection's current batch were written,
+// or the zero Time if they were not.
+// Like Transport.ReleaseTimings, it carries a monotonic clock reading.
+func (sc *StraddleConn) ReleasedAt() time.Time {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	return sc.releasedAt
+}
+
+// Err returns the error of the write of the held bytes, on fire or on Close, if it failed:
+// the server then never received the complete request. It returns nil otherwise. It tells
+// "the server processed it" apart from "the final bytes were never sent" (e.g. the peer
+// reset the connection while it was held).
+func (sc *StraddleConn) Err() error {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	return sc.err
+}
+
+// stamp sets the field of the request timing selected by field to at.
+// It is a no-op for connections not created through RoundTrip. Caller must hold sc.mu.
+func (sc *StraddleConn) stamp(field func(*RequestTiming) *time.Time, at time.Time) {
+	if sc.timing != nil {
+		sc.owner.stampAt(field(sc.timing), at)
+	}
+}
+
+// HandshakeContext runs the TLS handshake of the underlying connection, if it is a TLS
+// connection that has not completed it yet, bounded by the transport's HandshakeTimeout,
+// then checks the negotiated protocol (see checkProtocol), also for a connection the
+// TLSHandshaker already handshook. A failure is recorded as a dial error (see DialErrors).
+func (sc *StraddleConn) HandshakeContext(ctx context.Context) error {
+	hs, ok := sc.Conn.(interface{ HandshakeContext(context.Context) error })
+	if sc.owner == nil {
+		if !ok {
+			return nil
+		}
+		return hs.HandshakeContext(ctx)
+	}
+
+	ctx, cancel := withTimeout(ctx, sc.owner.HandshakeTimeout)
+	defer cancel()
+
+	var err error
+	if ok {
+		err = hs.HandshakeContext(ctx)
+	}
+	if err == nil {
+		err = sc.checkProtocol()
+	}
+	if err == nil && !sc.dialStart.IsZero() {
+		sc.owner.dialDone(sc.gen, sc.owner.since(sc.dialStart))
+	}
+	if err != nil {
+		sc.owner.inGen(sc.gen, func() {
+			sc.owner.dialFailed(err)
+		})
+	}
+	return err
+}
+
+// checkProtocol reports a negotiated protocol other than HTTP/1.1 (see Transport.OnProtocolMismatch).
+func (sc *StraddleConn) checkProtocol() error {
+	p := sc.ConnectionState().NegotiatedProtocol
+	if p == "" || p == "http/1.1" {
+		return nil
+	}
+	t := sc.owner
+	if t.OnProtocolMismatch != nil {
+		t.OnProtocolMismatch(p)
+		return nil
+	}
+	if t.ForceAttemptHTTP2 {
+		return nil
+	}
+	return fmt.Errorf("%w but server negotiated %q", ErrProtocolMismatch, p)
+}
+
+// Underlying returns the connection wrapped by sc, the same as sc.Conn: the TLS connection
+// for https, the plain one otherwise. Reads, deadlines and writes on it bypass the
+// straddling, so bytes written to it overtake the held ones.
+func (sc *StraddleConn) Underlying() net.Conn {
+	return sc.Conn
+}
+
+// ErrNoSyscallConn is returned by SyscallConn when no connection under sc has a file
+// descriptor, e.g. a net.Pipe of NewTestTransport.
+var ErrNoSyscallConn = errors.New("volley: no file descriptor under the connection")
+
+// SyscallConn returns the raw network connection under sc, looking through TLS layers
+// (including the TLS connection to an https proxy), e.g. to set socket options with
+// its Control method. It implements syscall.Conn.
+func (sc *StraddleConn) SyscallConn() (syscall.RawConn, error) {
+	c := sc.Conn
+	for {
+		switch cc := c.(type) {
+		case syscall.Conn:
+			return cc.SyscallConn()
+		case interface{ NetConn() net.Conn }:
+			c = cc.NetConn()
+		default:
+			return nil, ErrNoSyscallConn
+		}
+	}
+}
+
+// abandon closes a connection without sending its held bytes,
+// so the server never receives the complete request.
+func (sc *StraddleConn) abandon() {
+	sc.mu.Lock()
+	sc.held = nil
+	sc.mu.Unlock()
+	sc.Close()
+}
+
+// ConnectionState returns the TLS state of the underlying connection, if any.
+// net/http uses it to learn the protocol negotiated via ALPN.
+func (sc *StraddleConn) ConnectionState() tls.ConnectionState {
+	if cs, ok := sc.Conn.(interface{ ConnectionState() tls.ConnectionState }); ok {
+		return cs.ConnectionState()
+	}
+	return tls.ConnectionState{}
+}
+
+// writeUnheld writes b ahead of the request the connection holds, e.g. the leading requests
+// of a pipeline: b is sent as is, and the straddle point is only searched in the writes
+// that follow. It must be called before the first Write.
+func (sc *StraddleConn) writeUnheld(b []byte) (int, error) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	return sc.Conn.Write(b)
+}
+
+// uncork removes the TCP_CORK set when the connection armed, pushing out the bytes queued
+// under it. Caller must hold sc.mu.
+func (sc *StraddleConn) uncork() {
+	if sc.corked {
+		setCork(sc.Conn, false)
+		sc.corked = false
+	}
+}
+
+// flushHeld writes the held bytes, in order, as a single write. Caller must hold sc.mu.
+func (sc *StraddleConn) flushHeld() (int, error) {
+	held := sc.take()
+	if len(held) == 0 {
+		return 0, nil
+	}
+	return sc.Conn.Write(held)
+}
+
+// finalize is the finalizer of a connection dropped without Close (see wrapConn):
+// it closes the connection, so its counts are given back.
+func (sc *StraddleConn) finalize() {
+	sc.owner.logf("conn dropped without Close", "addr", sc.Addr, "label", sc.Label)
+	sc.Close()
+}
+
+func (sc *StraddleConn) Close() error {
+	sc.mu.Lock()
+
+	// Counters are only adjusted by the first Close
+	if sc.closed {
+		sc.mu.Unlock()
+		return sc.Conn.Close()
+	}
+	sc.closed = true
+	runtime.SetFinalizer(sc, nil)
+	if sc.stop != nil {
+		close(sc.stop)
+	}
+
+	t := sc.owner
+	if t == nil {
+		// Standalone: flush what is held, best effort
+		if _, err := sc.flushHeld(); err != nil && sc.err == nil {
+			sc.err = err
+		}
+		sc.mu.Unlock()
+		return sc.Conn.Close()
+	}
+
+	sc.owner.untrackLive(sc.Conn)
+
+	// If the connection was counted as "Held", we need to reverse that
+	// if it closes before firing.
+	if sc.isCounted {
+		// Only decrement if we haven't fired yet.
+		// If we fired, the counter is conceptually "consumed".
+		if !sc.fired() {
+			sc.owner.inGen(sc.gen, func() {
+				atomic.AddInt32(&sc.owner.heldCount, -1)
+				sc.owner.untrack(sc)
+			})
+		}
+		sc.isCounted = false
 
 		// Notify transport state change
 		sc.owner.tryNotify()
 	}
 
-	// Flush before closing (best effort)
-	if sc.hasHeld {
-		sc.Conn.Write([]byte{sc.held})
-		sc.hasHeld = false
+	// Flush before closing (best effort), unless the server gave up on the request
+	sc.uncork()
+	if sc.owner.ReadinessProbe && atomic.LoadInt32(&sc.unhealthy) == 1 {
+		sc.held = nil
+	}
+	if _, err := sc.flushHeld(); err != nil && sc.err == nil {
+		sc.err = err
 	}
+	closeErr := sc.err
+	sc.noteResult(func(r *ConnResult) {
+		r.Closed = true
+		r.Err = closeErr
+	})
 	sc.mu.Unlock()
 
 	// Decrement alive count
-	atomic.AddInt32(&sc.owner.aliveCount, -1)
+	sc.owner.inGen(sc.gen, func() {
+		atomic.AddInt32(&sc.owner.aliveCount, -1)
+	})
 	sc.owner.tryNotify()
+	sc.owner.emit(EventConnClosed, sc.Label, sc.Addr)
+	sc.owner.logf("conn closed", "addr", sc.Addr, "label", sc.Label)
 
 	return sc.Conn.Close()
 }
@@ -0,0 +1,1407 @@
+package volley
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httptrace"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// servePipe answers every request read from c with an empty 200 response, until c is closed.
+func servePipe(c net.Conn) {
+	defer c.Close()
+	br := bufio.NewReader(c)
+	for {
+		req, err := http.ReadRequest(br)
+		if err != nil {
+			return
+		}
+		req.Body.Close()
+		if _, err := c.Write([]byte("HTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\n")); err != nil {
+			return
+		}
+	}
+}
+
+// pipeDial is a dial function for NewTestTransport whose connections are served by servePipe.
+func pipeDial(ctx context.Context) (net.Conn, error) {
+	client, server := net.Pipe()
+	go servePipe(server)
+	return client, nil
+}
+
+// holdPipe wraps one end of a net.Pipe for vt, as a dial of the current batch would, and
+// writes a request to it, so it holds. The other end discards what it reads.
+func holdPipe(t *testing.T, vt *Transport) *StraddleConn {
+	t.Helper()
+	client, server := net.Pipe()
+	go io.Copy(io.Discard, server)
+	t.Cleanup(func() { server.Close() })
+
+	sc := vt.wrapConn(context.Background(), client, "volley.test:80", atomic.LoadUint32(&vt.gen),
+		vt.fireChAtom.Load().(chan struct{}), vt.abandonAtom.Load().(chan struct{}))
+	t.Cleanup(func() { sc.Close() })
+	if _, err := sc.Write([]byte("GET / HTTP/1.1\r\nHost: volley.test\r\n\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	return sc
+}
+
+// waitFor polls cond until it holds, failing the test after a few seconds.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// get sends a GET request through vt in the background and returns the channel receiving its error.
+func get(vt *Transport, url string) <-chan error {
+	errc := make(chan error, 1)
+	go func() {
+		resp, err := (&http.Client{Transport: vt}).Get(url)
+		if err == nil {
+			resp.Body.Close()
+		}
+		errc <- err
+	}()
+	return errc
+}
+
+// recvErr receives the error of a request started by get, failing the test if it hangs.
+func recvErr(t *testing.T, errc <-chan error) error {
+	t.Helper()
+	select {
+	case err := <-errc:
+		return err
+	case <-time.After(5 * time.Second):
+		t.Fatal("request hangs")
+		return nil
+	}
+}
+
+func TestResetAbandonsInFlightDial(t *testing.T) {
+	proceed := make(chan struct{})
+	vt := NewTestTransport(func(ctx context.Context) (net.Conn, error) {
+		<-proceed
+		return pipeDial(ctx)
+	})
+
+	errc := get(vt, "http://volley.test/")
+	waitFor(t, "the dial", func() bool { return vt.Stats().DialInflight == 1 })
+
+	// The dial completes after the batch was dropped: it must not hold forever
+	vt.Reset()
+	close(proceed)
+
+	if err := recvErr(t, errc); !errors.Is(err, ErrBatchAbandoned) {
+		t.Fatalf("err = %v, want ErrBatchAbandoned", err)
+	}
+	if s := vt.Stats(); s.Alive != 0 || s.Held != 0 || s.DialInflight != 0 {
+		t.Fatalf("stats after Reset = %+v, want zero counters", s)
+	}
+}
+
+func TestResetAbandonsConnNotArmed(t *testing.T) {
+	vt := NewTransport()
+	client, server := net.Pipe()
+	go servePipe(server)
+	sc := vt.wrapConn(context.Background(), client, "volley.test:80", 0,
+		vt.fireChAtom.Load().(chan struct{}), vt.abandonAtom.Load().(chan struct{}))
+	defer sc.Close()
+
+	// Dialed for the dropped batch, written to once the next one started
+	vt.Reset()
+	if _, err := sc.Write([]byte("GET / HTTP/1.1\r\n\r\n")); !errors.Is(err, ErrBatchAbandoned) {
+		t.Fatalf("Write err = %v, want ErrBatchAbandoned", err)
+	}
+	if s := vt.Stats(); s.Held != 0 {
+		t.Fatalf("Held = %d, want 0", s.Held)
+	}
+}
+
+func TestResetAfterFireKeepsInFlightDial(t *testing.T) {
+	proceed := make(chan struct{})
+	vt := NewTestTransport(func(ctx context.Context) (net.Conn, error) {
+		<-proceed
+		return pipeDial(ctx)
+	})
+
+	errc := get(vt, "http://volley.test/")
+	waitFor(t, "the dial", func() bool { return vt.Stats().DialInflight == 1 })
+
+	// The batch fired: its straggler writes through instead of failing
+	vt.Fire()
+	vt.Reset()
+	close(proceed)
+
+	if err := recvErr(t, errc); err != nil {
+		t.Fatalf("request of the fired batch: %v", err)
+	}
+}
+
+func TestAbortAbandonsInFlightDial(t *testing.T) {
+	proceed := make(chan struct{})
+	vt := NewTestTransport(func(ctx context.Context) (net.Conn, error) {
+		<-proceed
+		return pipeDial(ctx)
+	})
+
+	errc := get(vt, "http://volley.test/")
+	waitFor(t, "the dial", func() bool { return vt.Stats().DialInflight == 1 })
+
+	// Even after a fire, a straggler of an aborted batch must not reach the server
+	vt.Fire()
+	if err := vt.Abort(); err != nil {
+		t.Fatalf("Abort: %v", err)
+	}
+	close(proceed)
+
+	if err := recvErr(t, errc); !errors.Is(err, ErrBatchAbandoned) {
+		t.Fatalf("err = %v, want ErrBatchAbandoned", err)
+	}
+}
+
+func TestCloseDropsHeld(t *testing.T) {
+	var served int32
+	vt := NewTestTransport(func(ctx context.Context) (net.Conn, error) {
+		client, server := net.Pipe()
+		go func() {
+			defer server.Close()
+			if req, err := http.ReadRequest(bufio.NewReader(server)); err == nil {
+				req.Body.Close()
+				atomic.AddInt32(&served, 1)
+			}
+		}()
+		return client, nil
+	})
+
+	errc := get(vt, "http://volley.test/")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := vt.Wait(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	// Close drops the held request instead of sending it
+	if err := vt.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if err := recvErr(t, errc); err == nil {
+		t.Fatal("held request completed after Close")
+	}
+	if n := atomic.LoadInt32(&served); n != 0 {
+		t.Fatalf("server got %d requests, want 0", n)
+	}
+	if n, err := vt.Fire(); n != 0 || !errors.Is(err, ErrTransportClosed) {
+		t.Fatalf("Fire after Close = %d, %v, want 0, ErrTransportClosed", n, err)
+	}
+}
+
+func TestDialRetry(t *testing.T) {
+	var dials int32
+	vt := NewTestTransport(func(ctx context.Context) (net.Conn, error) {
+		if atomic.AddInt32(&dials, 1) == 1 {
+			return nil, errors.New("connection refused")
+		}
+		return pipeDial(ctx)
+	}, WithDialRetry(2, time.Millisecond))
+	defer vt.Close()
+
+	errc := get(vt, "http://volley.test/")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := vt.Wait(ctx, 1); err != nil {
+		t.Fatalf("Wait: %v", err)
+	}
+
+	// The retry counts as the same dial, which succeeded
+	if n := atomic.LoadInt32(&dials); n != 2 {
+		t.Fatalf("dialed %d times, want 2", n)
+	}
+	if s := vt.Stats(); s.DialStarted != 1 || s.DialInflight != 0 || s.Alive != 1 || s.Held != 1 {
+		t.Fatalf("stats = %+v, want 1 dial started, 0 inflight, 1 alive and held", s)
+	}
+	if errs := vt.DialErrors(); len(errs) != 0 {
+		t.Fatalf("DialErrors = %v, want none", errs)
+	}
+
+	vt.Fire()
+	if err := recvErr(t, errc); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWaitReturnsDialError(t *testing.T) {
+	// A port nobody listens on
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	refused := ln.Addr().String()
+	ln.Close()
+
+	cases := []struct {
+		name string
+		vt   *Transport
+		url  string
+		want error
+	}{
+		{"refused", NewTransport(), "http://" + refused + "/", syscall.ECONNREFUSED},
+		{"blackholed", NewTestTransport(func(ctx context.Context) (net.Conn, error) {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}, WithHandshakeTimeout(50*time.Millisecond)), "http://volley.test/", context.DeadlineExceeded},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			defer c.vt.Close()
+			errc := get(c.vt, c.url)
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if err := c.vt.Wait(ctx, 1); !errors.Is(err, c.want) {
+				t.Fatalf("Wait = %v, want %v", err, c.want)
+			}
+			if ctx.Err() != nil {
+				t.Fatal("Wait returned only at its deadline")
+			}
+			if err := recvErr(t, errc); err == nil {
+				t.Fatal("request succeeded")
+			}
+		})
+	}
+}
+
+func TestChunkedBodyHeld(t *testing.T) {
+	body := strings.Repeat("0123456789", 1000)
+	bodies := make(chan string, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Error(err)
+		}
+		if te := r.TransferEncoding; len(te) != 1 || te[0] != "chunked" {
+			t.Errorf("TransferEncoding = %v, want chunked", te)
+		}
+		bodies <- string(b)
+	}))
+	defer srv.Close()
+
+	vt := NewTransport()
+	defer vt.Close()
+	// A reader of unknown length: net/http sends the body chunked
+	req, err := http.NewRequest("POST", srv.URL, io.MultiReader(strings.NewReader(body)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	errc := make(chan error, 1)
+	go func() {
+		resp, err := vt.RoundTrip(req)
+		if err == nil {
+			resp.Body.Close()
+		}
+		errc <- err
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := vt.Wait(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-bodies:
+		t.Fatal("the server read the whole body before the fire")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	vt.Fire()
+	if err := recvErr(t, errc); err != nil {
+		t.Fatal(err)
+	}
+	if got := <-bodies; got != body {
+		t.Fatalf("server got %d bytes, want the %d bytes sent", len(got), len(body))
+	}
+}
+
+func TestExpectContinue(t *testing.T) {
+	const body = "hello"
+	headers := make(chan struct{}, 1)
+	bodies := make(chan string, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		headers <- struct{}{}
+		// The first read of the body answers "100 Continue"
+		b, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Error(err)
+		}
+		bodies <- string(b)
+	}))
+	defer srv.Close()
+
+	vt := NewTransport()
+	vt.ExpectContinueTimeout = 5 * time.Second
+	defer vt.Close()
+	req, err := http.NewRequest("POST", srv.URL, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Expect", "100-continue")
+	errc := make(chan error, 1)
+	go func() {
+		resp, err := vt.RoundTrip(req)
+		if err == nil {
+			resp.Body.Close()
+		}
+		errc <- err
+	}()
+
+	// The header block goes out whole; the body holds once the server asked for it
+	select {
+	case <-headers:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the server never got the headers")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := vt.Wait(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-bodies:
+		t.Fatal("the server read the whole body before the fire")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	vt.Fire()
+	if err := recvErr(t, errc); err != nil {
+		t.Fatal(err)
+	}
+	if got := <-bodies; got != body {
+		t.Fatalf("server got body %q, want %q", got, body)
+	}
+}
+
+func TestFireNWriteThrough(t *testing.T) {
+	const req = "GET / HTTP/1.1\r\nHost: volley.test\r\n\r\n"
+	vt := NewTransport()
+	var recs []*recConn
+	var scs []*StraddleConn
+	for i := 0; i < 2; i++ {
+		rc := &recConn{}
+		sc := wrapRec(vt, rc)
+		defer sc.Close()
+		if _, err := sc.Write([]byte(req)); err != nil {
+			t.Fatal(err)
+		}
+		recs, scs = append(recs, rc), append(scs, sc)
+	}
+
+	if n := vt.FireN(1); n != 1 {
+		t.Fatalf("FireN(1) = %d, want 1", n)
+	}
+	if got := recs[0].got(); got != req {
+		t.Fatalf("released conn sent %q, want %q", got, req)
+	}
+
+	// The released conn is not armed again: its next request goes straight through
+	if _, err := scs[0].Write([]byte(req)); err != nil {
+		t.Fatal(err)
+	}
+	if got := recs[0].got(); got != req+req {
+		t.Fatalf("released conn sent %q, want both requests", got)
+	}
+	if got := recs[1].got(); got != req[:len(req)-1] {
+		t.Fatalf("held conn sent %q, want all but the last byte", got)
+	}
+	if s := vt.Stats(); s.Held != 1 || s.Alive != 2 || s.Released != 1 || s.Fired {
+		t.Fatalf("stats = %+v, want 1 held, 2 alive, 1 released, not fired", s)
+	}
+
+	// Fire releases the other one only
+	if n, err := vt.Fire(); n != 1 || err != nil {
+		t.Fatalf("Fire = %d, %v, want 1", n, err)
+	}
+	if got := recs[1].got(); got != req {
+		t.Fatalf("held conn sent %q after fire, want %q", got, req)
+	}
+	if got := recs[0].got(); got != req+req {
+		t.Fatalf("released conn sent %q after fire, want both requests once", got)
+	}
+	if s := vt.Stats(); s.Alive != 2 || s.Released != 2 {
+		t.Fatalf("stats after fire = %+v, want 2 alive and released", s)
+	}
+}
+
+func TestLocalAddrFunc(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("binding to 127.0.0.x beyond 127.0.0.1 needs Linux")
+	}
+	remotes := make(chan string, 2)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		remotes <- r.RemoteAddr
+	}))
+	defer srv.Close()
+
+	sources := []string{"127.0.0.2", "127.0.0.3"}
+	vt := NewTransport(WithLocalAddrFunc(func(attempt int) net.Addr {
+		return &net.TCPAddr{IP: net.ParseIP(sources[attempt%len(sources)])}
+	}))
+	defer vt.Close()
+
+	// One dial at a time, so the attempts map to the requests in order
+	for _, want := range sources {
+		errc := get(vt, srv.URL)
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		err := vt.Wait(ctx, 1)
+		cancel()
+		if err != nil {
+			t.Fatal(err)
+		}
+		vt.Fire()
+		if err := recvErr(t, errc); err != nil {
+			t.Fatal(err)
+		}
+		host, _, err := net.SplitHostPort(<-remotes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if host != want {
+			t.Fatalf("server saw the request from %s, want %s", host, want)
+		}
+		vt.Reset()
+	}
+}
+
+func TestUnixSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "volley.sock")
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		t.Skipf("no unix sockets: %v", err)
+	}
+	var served int32
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&served, 1)
+	}))
+	srv.Listener.Close()
+	srv.Listener = ln
+	srv.Start()
+	defer srv.Close()
+
+	const want = 3
+	vt := NewTransport(WithUnixSocket(path))
+	defer vt.Close()
+	var errcs []<-chan error
+	for i := 0; i < want; i++ {
+		errcs = append(errcs, get(vt, "http://volley.test/"))
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := vt.Wait(ctx, want); err != nil {
+		t.Fatal(err)
+	}
+	if s := vt.Stats(); s.Held != want {
+		t.Fatalf("Held = %d, want %d", s.Held, want)
+	}
+	if got := atomic.LoadInt32(&served); got != 0 {
+		t.Fatalf("served %d before fire, want 0", got)
+	}
+
+	if n, err := vt.Fire(); n != want || err != nil {
+		t.Fatalf("Fire = %d, %v, want %d", n, err, want)
+	}
+	for _, errc := range errcs {
+		if err := recvErr(t, errc); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := atomic.LoadInt32(&served); got != want {
+		t.Fatalf("served %d, want %d", got, want)
+	}
+}
+
+func TestSpinLeadWriteDuringLead(t *testing.T) {
+	const req = "GET / HTTP/1.1\r\nHost: volley.test\r\n\r\n"
+	const lead = 100 * time.Millisecond
+	vt := NewTransport(WithSpinFire(lead))
+	held := wrapRec(vt, &recConn{})
+	defer held.Close()
+	if _, err := held.Write([]byte(req)); err != nil {
+		t.Fatal(err)
+	}
+	// A conn of the batch that writes its request once the fire started
+	rc := &recConn{}
+	late := wrapRec(vt, rc)
+	defer late.Close()
+
+	fired := make(chan struct{})
+	go func() {
+		defer close(fired)
+		vt.Fire()
+	}()
+	waitFor(t, "the fire", func() bool { return vt.Stats().Fired })
+	if _, err := late.Write([]byte(req)); err != nil {
+		t.Fatal(err)
+	}
+	// Not before the trigger: the write waited for it
+	if trigger := vt.fireTime(); time.Now().Before(trigger) {
+		t.Fatalf("written %v ahead of the trigger", time.Until(trigger))
+	}
+	if got := rc.got(); got != req {
+		t.Fatalf("late conn sent %q, want %q", got, req)
+	}
+	<-fired
+
+	if min, _, _ := vt.FireJitter(); min < 0 {
+		t.Fatalf("FireJitter min = %v, want no negative lag", min)
+	}
+}
+
+func TestAbortClosesWarm(t *testing.T) {
+	var opened, closed int32
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	srv.Config.ConnState = func(c net.Conn, state http.ConnState) {
+		switch state {
+		case http.StateNew:
+			atomic.AddInt32(&opened, 1)
+		case http.StateClosed:
+			atomic.AddInt32(&closed, 1)
+		}
+	}
+	srv.Start()
+	defer srv.Close()
+
+	vt := NewTransport()
+	defer vt.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := vt.Prewarm(ctx, []string{srv.URL}, 2); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the warm conns", func() bool { return atomic.LoadInt32(&opened) == 2 })
+
+	if err := vt.Abort(); err != nil {
+		t.Fatalf("Abort: %v", err)
+	}
+	waitFor(t, "the warm conns to close", func() bool { return atomic.LoadInt32(&closed) == 2 })
+
+	// The pool is gone: the next request dials
+	errc := get(vt, srv.URL)
+	if err := vt.Wait(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	vt.Fire()
+	if err := recvErr(t, errc); err != nil {
+		t.Fatal(err)
+	}
+	if got := atomic.LoadInt32(&opened); got != 3 {
+		t.Fatalf("server saw %d conns, want 3", got)
+	}
+}
+
+// BenchmarkFireSpread measures how tight a volley lands: a loopback server records when
+// each request of a volley arrives, and the p50/p99 delays from the first arrival of the
+// volley are reported as custom metrics. Run it with go test -bench FireSpread.
+func BenchmarkFireSpread(b *testing.B) {
+	const volley = 20
+
+	benches := []struct {
+		name string
+		opts []Option
+		fire func(*Transport)
+	}{
+		{"broadcast", nil, func(vt *Transport) { vt.Fire() }},
+		{"hold4", []Option{WithHoldBytes(4)}, func(vt *Transport) { vt.Fire() }},
+		{"nagle", []Option{WithNoDelay(false)}, func(vt *Transport) { vt.Fire() }},
+		{"staggered", nil, func(vt *Transport) { vt.FireStaggered(0) }},
+	}
+	for _, bb := range benches {
+		b.Run(bb.name, func(b *testing.B) {
+			var mu sync.Mutex
+			var arrivals []time.Time
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				arrivals = append(arrivals, time.Now())
+				mu.Unlock()
+			}))
+			defer srv.Close()
+
+			vt := NewTransport(bb.opts...)
+			defer vt.Close()
+			client := &http.Client{Transport: vt}
+
+			var spreads []time.Duration
+			for i := 0; i < b.N; i++ {
+				vt.Reset()
+				mu.Lock()
+				arrivals = arrivals[:0]
+				mu.Unlock()
+
+				var wg sync.WaitGroup
+				for j := 0; j < volley; j++ {
+					wg.Add(1)
+					go func() {
+						defer wg.Done()
+						if resp, err := client.Get(srv.URL); err == nil {
+							resp.Body.Close()
+						}
+					}()
+				}
+				ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+				err := vt.Wait(ctx, volley)
+				cancel()
+				if err != nil {
+					b.Fatal(err)
+				}
+				bb.fire(vt)
+				wg.Wait()
+
+				mu.Lock()
+				sort.Slice(arrivals, func(i, j int) bool { return arrivals[i].Before(arrivals[j]) })
+				for _, at := range arrivals {
+					spreads = append(spreads, at.Sub(arrivals[0]))
+				}
+				mu.Unlock()
+			}
+
+			sort.Slice(spreads, func(i, j int) bool { return spreads[i] < spreads[j] })
+			b.ReportMetric(float64(percentile(spreads, 50).Microseconds()), "p50-spread-us")
+			b.ReportMetric(float64(percentile(spreads, 99).Microseconds()), "p99-spread-us")
+		})
+	}
+}
+
+// percentile returns the p-th percentile of the sorted durations d.
+func percentile(d []time.Duration, p int) time.Duration {
+	if len(d) == 0 {
+		return 0
+	}
+	return d[(len(d)-1)*p/100]
+}
+
+func TestFireContextReturnsCtxErr(t *testing.T) {
+	vt := NewTestTransport(pipeDial)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	errc := make(chan error, 1)
+	go func() { errc <- vt.FireContext(ctx) }()
+
+	// Fired by other means: FireContext still waits for ctx and does not fire again
+	vt.Fire()
+	select {
+	case err := <-errc:
+		t.Fatalf("FireContext returned %v before ctx was done", err)
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	cancel()
+	if err := <-errc; err != context.Canceled {
+		t.Fatalf("FireContext = %v, want context.Canceled", err)
+	}
+	if s := vt.Stats(); s.TotalFires != 1 {
+		t.Fatalf("TotalFires = %d, want 1", s.TotalFires)
+	}
+}
+
+func TestCancelAfterDialReleasesAlive(t *testing.T) {
+	dialed := make(chan struct{})
+	proceed := make(chan struct{})
+	vt := NewTestTransport(func(ctx context.Context) (net.Conn, error) {
+		close(dialed)
+		<-proceed
+		return pipeDial(ctx)
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errc := make(chan error, 1)
+	go func() {
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet, "http://volley.test/", nil)
+		resp, err := (&http.Client{Transport: vt}).Do(req)
+		if err == nil {
+			resp.Body.Close()
+		}
+		errc <- err
+	}()
+
+	// The request is cancelled while its dial completes: the conn is never written to
+	<-dialed
+	cancel()
+	close(proceed)
+	if err := recvErr(t, errc); err == nil {
+		t.Fatal("cancelled request succeeded")
+	}
+	waitFor(t, "Alive back to 0", func() bool { return vt.Stats().Alive == 0 })
+}
+
+func TestUnclosedConnReleasesAlive(t *testing.T) {
+	l := &lockCheckLogger{t: t}
+	vt := NewTransport(WithLogger(l))
+	c, _ := net.Pipe()
+	sc := wrapRec(vt, c)
+	if s := vt.Stats(); s.Alive != 1 {
+		t.Fatalf("Alive = %d, want 1", s.Alive)
+	}
+
+	// Dropped without Close: the finalizer gives its count back
+	sc.finalize()
+	if s := vt.Stats(); s.Alive != 0 {
+		t.Fatalf("Alive after the finalizer = %d, want 0", s.Alive)
+	}
+	if !l.logged("volley: conn dropped without Close") {
+		t.Fatal("the dropped conn was not logged")
+	}
+}
+
+func TestResetWhileOldConnsClose(t *testing.T) {
+	const n = 20
+	vt := NewTransport()
+	for round := 0; round < 5; round++ {
+		var old []*StraddleConn
+		for i := 0; i < n; i++ {
+			old = append(old, holdPipe(t, vt))
+		}
+
+		// The conns of the dropped batch close as the next batch starts
+		var wg sync.WaitGroup
+		for _, sc := range old {
+			wg.Add(1)
+			go func(sc *StraddleConn) {
+				defer wg.Done()
+				sc.Close()
+			}(sc)
+		}
+		vt.Reset()
+		wg.Wait()
+
+		if s := vt.Stats(); s.Alive != 0 || s.Held != 0 {
+			t.Fatalf("round %d: stats after Reset = %+v, want zero counters", round, s)
+		}
+		sc := holdPipe(t, vt)
+		if s := vt.Stats(); s.Alive != 1 || s.Held != 1 {
+			t.Fatalf("round %d: stats of the new batch = %+v, want 1 alive and held", round, s)
+		}
+		sc.Close()
+		vt.Reset()
+	}
+}
+
+func TestServerName(t *testing.T) {
+	cases := []struct {
+		name string
+		addr string
+		want string
+	}{
+		{"IPv4", "192.0.2.1:443", "192.0.2.1"},
+		{"IPv6 literal", "[2001:db8::1]:443", "2001:db8::1"},
+		{"IPv6 literal without port", "[::1]", "::1"},
+		{"hostname with port", "example.com:8443", "example.com"},
+		{"bare hostname", "example.com", "example.com"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := serverName(c.addr); got != c.want {
+				t.Errorf("serverName(%q) = %q, want %q", c.addr, got, c.want)
+			}
+		})
+	}
+}
+
+// discardConn is a net.Conn discarding what is written to it.
+type discardConn struct{ net.Conn }
+
+func (discardConn) Write(b []byte) (int, error) { return len(b), nil }
+func (discardConn) RemoteAddr() net.Addr        { return nil }
+func (discardConn) Close() error                { return nil }
+
+// holdDiscard arms n connections of vt on discardConns and returns them.
+func holdDiscard(b *testing.B, vt *Transport, n int) []*StraddleConn {
+	b.Helper()
+	conns := make([]*StraddleConn, n)
+	for i := range conns {
+		conns[i] = wrapRec(vt, discardConn{})
+		if _, err := conns[i].Write([]byte("GET / HTTP/1.1\r\nHost: volley.test\r\n\r\n")); err != nil {
+			b.Fatal(err)
+		}
+	}
+	return conns
+}
+
+// BenchmarkRelease10k compares the release of 10k held connections by one goroutine per
+// connection waiting for the fire channel (the former design) and by Fire walking the
+// registry of held connections. Each op is a fire, until every connection is released;
+// maxlag-us is the mean of the largest delay between the fire and a release. On one CPU
+// (linux/amd64), go test -bench Release10k gave:
+//
+//	goroutine-per-conn   14.2 ms/op   14190 maxlag-us
+//	dispatcher            4.2 ms/op    4176 maxlag-us
+func BenchmarkRelease10k(b *testing.B) {
+	const n = 10000
+
+	b.Run("goroutine-per-conn", func(b *testing.B) {
+		var maxLag time.Duration
+		for i := 0; i < b.N; i++ {
+			b.StopTimer()
+			vt := NewTransport()
+			conns := holdDiscard(b, vt, n)
+			fire := make(chan struct{})
+			var trigger time.Time
+			var lagMu sync.Mutex
+			var lag time.Duration
+			var wg sync.WaitGroup
+			for _, sc := range conns {
+				wg.Add(1)
+				go func(sc *StraddleConn) {
+					defer wg.Done()
+					<-fire
+					sc.release(trigger)
+					d := time.Since(trigger)
+					lagMu.Lock()
+					if d > lag {
+						lag = d
+					}
+					lagMu.Unlock()
+				}(sc)
+			}
+			b.StartTimer()
+
+			trigger = time.Now()
+			close(fire)
+			wg.Wait()
+			maxLag += lag
+		}
+		b.ReportMetric(float64(maxLag.Microseconds())/float64(b.N), "maxlag-us")
+	})
+
+	b.Run("dispatcher", func(b *testing.B) {
+		var maxLag time.Duration
+		for i := 0; i < b.N; i++ {
+			b.StopTimer()
+			vt := NewTransport()
+			holdDiscard(b, vt, n)
+			b.StartTimer()
+
+			if got, _ := vt.Fire(); got != n {
+				b.Fatalf("released %d, want %d", got, n)
+			}
+			_, lag, _ := vt.FireJitter()
+			maxLag += lag
+		}
+		b.ReportMetric(float64(maxLag.Microseconds())/float64(b.N), "maxlag-us")
+	})
+}
+
+// BenchmarkSpinLead compares the release jitter of 100 held connections fired right away
+// and with a spin lead (see Transport.SpinLead). jitter-us is the mean spread between the
+// earliest and the latest release of a fire, maxlag-us the mean of the latest release.
+// On one CPU (linux/amd64), go test -bench SpinLead gave:
+//
+//	lead=0s       53.8 µs/op   51.4 jitter-us   52.3 maxlag-us
+//	lead=200µs   273.0 µs/op   46.7 jitter-us   72.4 maxlag-us
+func BenchmarkSpinLead(b *testing.B) {
+	const n = 100
+
+	for _, lead := range []time.Duration{0, 200 * time.Microsecond} {
+		b.Run(fmt.Sprintf("lead=%v", lead), func(b *testing.B) {
+			var spread, maxLag time.Duration
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				vt := NewTransport(WithSpinFire(lead))
+				holdDiscard(b, vt, n)
+				b.StartTimer()
+
+				if got, _ := vt.Fire(); got != n {
+					b.Fatalf("released %d, want %d", got, n)
+				}
+				min, max, _ := vt.FireJitter()
+				spread += max - min
+				maxLag += max
+			}
+			b.ReportMetric(float64(spread.Microseconds())/float64(b.N), "jitter-us")
+			b.ReportMetric(float64(maxLag.Microseconds())/float64(b.N), "maxlag-us")
+		})
+	}
+}
+
+// BenchmarkPark10k compares the cost of holding 10k connections with one goroutine per
+// connection parked on the fire and close channels (the former waitForFire) and with the
+// registry of held connections Fire walks. Each op arms the connections and closes them
+// unfired; goroutines is the number running while they are held. On one CPU
+// (linux/amd64), go test -bench Park10k gave:
+//
+//	goroutine-per-conn   90.6 ms/op   10003 goroutines   9.9 MB/op   141171 allocs/op
+//	registry             70.9 ms/op       3 goroutines   9.2 MB/op   119797 allocs/op
+//
+// B/op leaves out the goroutine stacks, at least 2 KB each.
+func BenchmarkPark10k(b *testing.B) {
+	const n = 10000
+
+	park := func(b *testing.B, perConn bool) {
+		b.ReportAllocs()
+		var goroutines int
+		for i := 0; i < b.N; i++ {
+			vt := NewTransport()
+			conns := holdDiscard(b, vt, n)
+
+			var wg sync.WaitGroup
+			closeCh := make(chan struct{})
+			if perConn {
+				fire := make(chan struct{})
+				for _, sc := range conns {
+					wg.Add(1)
+					go func(sc *StraddleConn) {
+						defer wg.Done()
+						select {
+						case <-fire:
+							sc.release(time.Now())
+						case <-closeCh:
+						}
+					}(sc)
+				}
+			}
+			goroutines = runtime.NumGoroutine()
+
+			close(closeCh)
+			for _, sc := range conns {
+				sc.Close()
+			}
+			wg.Wait()
+		}
+		b.ReportMetric(float64(goroutines), "goroutines")
+	}
+
+	b.Run("goroutine-per-conn", func(b *testing.B) { park(b, true) })
+	b.Run("registry", func(b *testing.B) { park(b, false) })
+}
+
+func TestPeerClosesBeforeFire(t *testing.T) {
+	vt := NewTransport()
+	var reported []error
+	vt.OnReleaseError = func(sc *StraddleConn, err error) { reported = append(reported, err) }
+
+	client, server := net.Pipe()
+	req := "GET / HTTP/1.1\r\nHost: volley.test\r\n\r\n"
+	closed := make(chan struct{})
+	go func() {
+		// Read what is sent before the fire, then give up on the request
+		io.ReadFull(server, make([]byte, len(req)-1))
+		server.Close()
+		close(closed)
+	}()
+	sc := wrapRec(vt, client)
+	defer sc.Close()
+	if _, err := sc.Write([]byte(req)); err != nil {
+		t.Fatal(err)
+	}
+	<-closed
+
+	if got, _ := vt.Fire(); got != 0 {
+		t.Fatalf("Fire released %d, want 0", got)
+	}
+	if err := sc.Err(); !errors.Is(err, io.ErrClosedPipe) {
+		t.Fatalf("Err = %v, want io.ErrClosedPipe", err)
+	}
+	if errs := vt.ReleaseErrors(); len(errs) != 1 || errs[0] != sc.Err() {
+		t.Fatalf("ReleaseErrors = %v, want [%v]", errs, sc.Err())
+	}
+	if len(reported) != 1 {
+		t.Fatalf("OnReleaseError called %d times, want 1", len(reported))
+	}
+}
+
+func TestNotifierStress(t *testing.T) {
+	const writers = 100
+	for round := 0; round < 200; round++ {
+		var n notifier
+		var state int32
+
+		wake := n.subscribe()
+		for i := 0; i < writers; i++ {
+			go func() {
+				atomic.AddInt32(&state, 1)
+				n.broadcast()
+			}()
+		}
+
+		// Wait as Transport.Wait does: the last transition must not be lost
+		timeout := time.After(5 * time.Second)
+		for atomic.LoadInt32(&state) < writers {
+			select {
+			case <-wake:
+				n.ack()
+			case <-timeout:
+				t.Fatalf("round %d: missed a wakeup, state = %d", round, atomic.LoadInt32(&state))
+			}
+		}
+		n.unsubscribe(wake)
+	}
+}
+
+func TestMaxConcurrentDials(t *testing.T) {
+	const limit, want = 3, 30
+	var cur, peak int32
+	vt := NewTestTransport(func(ctx context.Context) (net.Conn, error) {
+		n := atomic.AddInt32(&cur, 1)
+		defer atomic.AddInt32(&cur, -1)
+		for p := atomic.LoadInt32(&peak); n > p && !atomic.CompareAndSwapInt32(&peak, p, n); p = atomic.LoadInt32(&peak) {
+		}
+		time.Sleep(2 * time.Millisecond)
+		return pipeDial(ctx)
+	}, WithMaxConcurrentDials(limit))
+
+	var errcs []<-chan error
+	for i := 0; i < want; i++ {
+		errcs = append(errcs, get(vt, "http://volley.test/"))
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := vt.Wait(ctx, want); err != nil {
+		t.Fatal(err)
+	}
+	if p := atomic.LoadInt32(&peak); p > limit {
+		t.Fatalf("%d dials in flight at once, want at most %d", p, limit)
+	}
+
+	vt.Fire()
+	for _, errc := range errcs {
+		if err := recvErr(t, errc); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestFireAsWriteLands(t *testing.T) {
+	for i := 0; i < 500; i++ {
+		vt := NewTransport()
+		client, server := net.Pipe()
+		got := make(chan string, 1)
+		go func() {
+			b, _ := io.ReadAll(server)
+			got <- string(b)
+		}()
+		sc := wrapRec(vt, client)
+		if _, err := sc.Write([]byte("abc")); err != nil {
+			t.Fatal(err)
+		}
+
+		// The held "c" goes out exactly once, ahead of "def", whoever flushes it
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			vt.Fire()
+		}()
+		go func() {
+			defer wg.Done()
+			for atomic.LoadInt32(&vt.fired) == 0 {
+				runtime.Gosched()
+			}
+			if _, err := sc.Write([]byte("def")); err != nil {
+				t.Error(err)
+			}
+		}()
+		wg.Wait()
+		sc.Close()
+		if s := <-got; s != "abcdef" {
+			t.Fatalf("iteration %d: server got %q, want %q", i, s, "abcdef")
+		}
+	}
+}
+
+func TestFireResetHammer(t *testing.T) {
+	vt := NewTestTransport(func(ctx context.Context) (net.Conn, error) {
+		client, server := net.Pipe()
+		go io.Copy(io.Discard, server)
+		return client, nil
+	})
+
+	// Any double close of a fire channel panics
+	const rounds = 100
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	var loops []*int32
+	loop := func(f func()) {
+		n := new(int32)
+		loops = append(loops, n)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+					f()
+					atomic.AddInt32(n, 1)
+				}
+			}
+		}()
+	}
+	loop(func() { vt.Fire() })
+	loop(func() { vt.Fire() })
+	loop(func() { vt.FireN(1) })
+	loop(func() { vt.FireHost("volley.test") })
+	loop(func() { vt.FireStaggered(0) })
+	loop(vt.Reset)
+	loop(func() {
+		c, err := vt.Transport.DialContext(context.Background(), "tcp", "volley.test:80")
+		if err != nil {
+			return
+		}
+		c.Write([]byte("GET / HTTP/1.1\r\n\r\n"))
+		c.Close()
+	})
+	// Run until every loop went through enough rounds to interleave with the others
+	waitFor(t, "the loops", func() bool {
+		for _, n := range loops {
+			if atomic.LoadInt32(n) < rounds {
+				return false
+			}
+		}
+		return true
+	})
+	close(stop)
+	wg.Wait()
+
+	vt.Reset()
+	if s := vt.Stats(); s.Fired || s.Held != 0 || s.Alive != 0 {
+		t.Fatalf("stats after the final Reset = %+v, want a clean batch", s)
+	}
+}
+
+func TestWaitStress(t *testing.T) {
+	const n = 2000
+	for round := 0; round < 5; round++ {
+		var dials int32
+		vt := NewTestTransport(func(ctx context.Context) (net.Conn, error) {
+			if atomic.AddInt32(&dials, 1)%7 == 0 {
+				return nil, errors.New("flaky dial")
+			}
+			client, server := net.Pipe()
+			go io.Copy(io.Discard, server)
+			return client, nil
+		})
+
+		conns := make(chan net.Conn, n)
+		for i := 0; i < n; i++ {
+			go func() {
+				c, err := vt.Transport.DialContext(context.Background(), "tcp", "volley.test:80")
+				if err != nil {
+					return
+				}
+				c.Write([]byte("GET / HTTP/1.1\r\n\r\n"))
+				conns <- c
+			}()
+		}
+
+		// Every dial settles, held or failed: Wait must see the last transition
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		err := vt.Wait(ctx, n)
+		cancel()
+		s := vt.Stats()
+		if err != nil || s.Held != s.Alive || s.Held != n-n/7 {
+			t.Fatalf("round %d: Wait = %v, stats = %+v, want %d held", round, err, s, n-n/7)
+		}
+
+		vt.Fire()
+		for i := 0; i < s.Held; i++ {
+			(<-conns).Close()
+		}
+	}
+}
+
+// rootCAs returns the pool trusting the certificate of the TLS test server srv.
+func rootCAs(srv *httptest.Server) *x509.CertPool {
+	return srv.Client().Transport.(*http.Transport).TLSClientConfig.RootCAs
+}
+
+// handshakenConn is a TLS conn a TLSHandshaker already handshook: it exposes the
+// negotiated protocol but no HandshakeContext method.
+type handshakenConn struct {
+	net.Conn
+	cs tls.ConnectionState
+}
+
+func (c *handshakenConn) ConnectionState() tls.ConnectionState { return c.cs }
+
+// handshaker returns a TLSHandshaker completing the handshake over tls.Client with cfg.
+func handshaker(cfg *tls.Config) func(net.Conn, string) (net.Conn, error) {
+	return func(rawConn net.Conn, serverName string) (net.Conn, error) {
+		cfg := cfg.Clone()
+		cfg.ServerName = serverName
+		tc := tls.Client(rawConn, cfg)
+		if err := tc.Handshake(); err != nil {
+			return nil, err
+		}
+		return &handshakenConn{Conn: tc, cs: tc.ConnectionState()}, nil
+	}
+}
+
+func TestTLSHandshakerStraddles(t *testing.T) {
+	var served int32
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&served, 1)
+	}))
+	defer srv.Close()
+
+	const want = 2
+	vt := NewTransport(WithTLSHandshaker(handshaker(&tls.Config{
+		RootCAs:    rootCAs(srv),
+		NextProtos: []string{"http/1.1"},
+	})))
+	defer vt.Close()
+	var errcs []<-chan error
+	for i := 0; i < want; i++ {
+		errcs = append(errcs, get(vt, srv.URL))
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := vt.Wait(ctx, want); err != nil {
+		t.Fatal(err)
+	}
+	if s := vt.Stats(); s.Held != want || s.Alive != want {
+		t.Fatalf("stats = %+v, want %d held and alive", s, want)
+	}
+	if got := atomic.LoadInt32(&served); got != 0 {
+		t.Fatalf("served %d before fire, want 0", got)
+	}
+
+	vt.Fire()
+	for _, errc := range errcs {
+		if err := recvErr(t, errc); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestTLSHandshakerProtocolMismatch(t *testing.T) {
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	srv.TLS = &tls.Config{NextProtos: []string{"h2"}}
+	srv.StartTLS()
+	defer srv.Close()
+
+	// The handshaker returns a conn already speaking h2, without HandshakeContext
+	vt := NewTransport(WithTLSHandshaker(handshaker(&tls.Config{
+		RootCAs:    rootCAs(srv),
+		NextProtos: []string{"h2", "http/1.1"},
+	})))
+	defer vt.Close()
+	if err := recvErr(t, get(vt, srv.URL)); !errors.Is(err, ErrProtocolMismatch) {
+		t.Fatalf("err = %v, want ErrProtocolMismatch", err)
+	}
+}
+
+func TestOnProtocolMismatch(t *testing.T) {
+	srv := newH2Server(t, func(w http.ResponseWriter, r *http.Request) {})
+
+	negotiated := make(chan string, 1)
+	vt := NewTransport(WithHoldBytes(0), WithTLSConfig(&tls.Config{
+		RootCAs:    rootCAs(srv),
+		NextProtos: []string{"h2", "http/1.1"},
+	}))
+	vt.OnProtocolMismatch = func(p string) { negotiated <- p }
+	defer vt.Close()
+
+	// The conn is used anyway: the HTTP/1.1 request itself fails against the h2 server
+	recvErr(t, get(vt, srv.URL))
+	select {
+	case p := <-negotiated:
+		if p != "h2" {
+			t.Fatalf("OnProtocolMismatch(%q), want %q", p, "h2")
+		}
+	default:
+		t.Fatal("OnProtocolMismatch was not called")
+	}
+}
+
+func TestProtocolMismatchH2Only(t *testing.T) {
+	srv := newH2Server(t, func(w http.ResponseWriter, r *http.Request) {})
+	cfg := &tls.Config{RootCAs: rootCAs(srv), NextProtos: []string{"h2", "http/1.1"}}
+
+	vt := NewTransport(WithHoldBytes(0), WithTLSConfig(cfg))
+	defer vt.Close()
+	if err := recvErr(t, get(vt, srv.URL)); !errors.Is(err, ErrProtocolMismatch) {
+		t.Fatalf("err = %v, want ErrProtocolMismatch", err)
+	}
+
+	// With HTTP/2 enabled, the request goes through over h2
+	h2 := NewTransport(WithHoldBytes(0), WithTLSConfig(cfg), WithHTTP2(true))
+	defer h2.Close()
+	if err := recvErr(t, get(h2, srv.URL)); err != nil {
+		t.Fatalf("with ForceAttemptHTTP2: %v", err)
+	}
+}
+
+func TestTLSHandshakeTrace(t *testing.T) {
+	var served int32
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&served, 1)
+	}))
+	defer srv.Close()
+
+	vt := NewTransport(WithTLSConfig(&tls.Config{RootCAs: rootCAs(srv), NextProtos: []string{"http/1.1"}}))
+	defer vt.Close()
+
+	var starts, dones int32
+	var state tls.ConnectionState
+	var hsErr error
+	trace := &httptrace.ClientTrace{
+		TLSHandshakeStart: func() { atomic.AddInt32(&starts, 1) },
+		TLSHandshakeDone: func(cs tls.ConnectionState, err error) {
+			state, hsErr = cs, err
+			atomic.AddInt32(&dones, 1)
+		},
+	}
+	req, err := http.NewRequestWithContext(httptrace.WithClientTrace(context.Background(), trace), "GET", srv.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	errc := make(chan error, 1)
+	go func() {
+		resp, err := vt.RoundTrip(req)
+		if err == nil {
+			resp.Body.Close()
+		}
+		errc <- err
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := vt.Wait(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	// The hooks bracket the handshake of the held conn, once each
+	if s, d := atomic.LoadInt32(&starts), atomic.LoadInt32(&dones); s != 1 || d != 1 {
+		t.Fatalf("TLSHandshakeStart x%d, TLSHandshakeDone x%d, want 1 each", s, d)
+	}
+	if hsErr != nil || !state.HandshakeComplete {
+		t.Fatalf("TLSHandshakeDone(complete %v, %v), want a complete handshake", state.HandshakeComplete, hsErr)
+	}
+	if got := atomic.LoadInt32(&served); got != 0 {
+		t.Fatalf("served %d before fire, want 0", got)
+	}
+
+	vt.Fire()
+	if err := recvErr(t, errc); err != nil {
+		t.Fatal(err)
+	}
+}
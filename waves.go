@@ -0,0 +1,97 @@
+package volley
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+// Wave describes one volley fired by FireWaves.
+type Wave struct {
+	// At is when the wave fired.
+	At time.Time
+	// Released is the number of connections released by the wave.
+	Released int
+	// FireToWrite summarizes the delays from the fire to the end of each held-byte write
+	// of the wave (see Transport.FireToWrite).
+	FireToWrite DurationStats
+}
+
+// FireWaves fires waves volleys on the warm connections of a keep-alive transport (see
+// WithKeepAlive), interval apart: it fires the current batch, rearms (see Rearm), waits for
+// the batch to hold again and fires it once the next tick comes, and so on. A wave armed
+// late fires as soon as it is held; the following ticks keep their schedule.
+//
+// The size of a wave is the number of connections held when FireWaves is called, so call
+// it after Wait; the caller keeps sending one request per connection and wave, e.g. from
+// goroutines looping on the client. It returns the waves fired so far, and the error that
+// stopped it: ErrKeepAliveDisabled, or the error of ctx while waiting. The transport is
+// left fired after the last wave.
+func (t *Transport) FireWaves(ctx context.Context, waves int, interval time.Duration) ([]Wave, error) {
+	if t.DisableKeepAlives {
+		return nil, ErrKeepAliveDisabled
+	}
+	want := int(atomic.LoadInt32(&t.heldCount))
+
+	var out []Wave
+	var start time.Time
+	for i := 0; i < waves; i++ {
+		if i > 0 {
+			if err := t.Rearm(); err != nil {
+				return out, err
+			}
+			if err := t.waitHeldCount(ctx, want); err != nil {
+				return out, err
+			}
+			if err := t.sleepCtx(ctx, start.Add(time.Duration(i)*interval)); err != nil {
+				return out, err
+			}
+		}
+
+		at := t.now()
+		if i == 0 {
+			start = at
+		}
+		released, err := t.Fire()
+		if err != nil {
+			return out, err
+		}
+		out = append(out, Wave{At: at, Released: released, FireToWrite: t.FireToWriteStats()})
+	}
+	return out, nil
+}
+
+// waitHeldCount blocks until want connections are held. Unlike WaitHeld, it does not
+// settle for every alive connection being held: rejoining connections count as alive
+// one by one, as their next request arrives.
+func (t *Transport) waitHeldCount(ctx context.Context, want int) error {
+	wake := t.waiters.subscribe()
+	defer t.waiters.unsubscribe(wake)
+
+	for atomic.LoadInt32(&t.heldCount) < int32(want) {
+		select {
+		case <-ctx.Done():
+			return t.timeoutErr(ctx.Err(), want)
+		case <-wake:
+			t.waiters.ack()
+		}
+	}
+	return nil
+}
+
+// sleepCtx waits until deadline, or until ctx is done.
+func (t *Transport) sleepCtx(ctx context.Context, deadline time.Time) error {
+	d := deadline.Sub(t.now())
+	if d <= 0 {
+		return nil
+	}
+	timer := t.newTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C():
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,90 @@
+package volley
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+)
+
+// websocketGUID is appended to the key of a WebSocket handshake to compute the accept
+// value (RFC 6455, section 1.3).
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// ErrBadHandshake is returned by DialWebSocket when the server does not complete the upgrade.
+var ErrBadHandshake = errors.New("volley: bad websocket handshake")
+
+// DialWebSocket sends the WebSocket opening handshake for rawURL (ws://, wss://, or their
+// http(s):// equivalents) through the transport, so it is held like any other request:
+// call it from one goroutine per client, Wait, then Fire, and every upgrade completes at
+// once. It returns the upgraded connection, which carries raw WebSocket frames, and the
+// 101 response; on a failed upgrade it returns the response, if any, with ErrBadHandshake.
+//
+// header is added to the handshake request, e.g. Sec-WebSocket-Protocol to race the
+// subprotocol selection, or Origin and cookies.
+//
+// Keep-alives must stay disabled (the default): the upgraded connection leaves net/http
+// for good, so it could not be rearmed anyway, and with keep-alives a handshake may reuse
+// a connection straddled for another batch.
+func (t *Transport) DialWebSocket(ctx context.Context, rawURL string, header http.Header) (io.ReadWriteCloser, *http.Response, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, nil, err
+	}
+	switch u.Scheme {
+	case "ws":
+		u.Scheme = "http"
+	case "wss":
+		u.Scheme = "https"
+	case "http", "https":
+	default:
+		return nil, nil, fmt.Errorf("volley: unsupported websocket scheme %q", u.Scheme)
+	}
+
+	var nonce [16]byte
+	if _, err := rand.Read(nonce[:]); err != nil {
+		return nil, nil, err
+	}
+	key := base64.StdEncoding.EncodeToString(nonce[:])
+
+	req, err := http.NewRequestWithContext(ctx, "GET", u.String(), nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	for k, vs := range header {
+		req.Header[k] = append(req.Header[k], vs...)
+	}
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Key", key)
+
+	resp, err := t.RoundTrip(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols ||
+		resp.Header.Get("Sec-WebSocket-Accept") != websocketAccept(key) {
+		resp.Body.Close()
+		return nil, resp, fmt.Errorf("%w: status %q", ErrBadHandshake, resp.Status)
+	}
+
+	// For a 101 response, net/http hands over the connection as the body
+	conn, ok := resp.Body.(io.ReadWriteCloser)
+	if !ok {
+		resp.Body.Close()
+		return nil, resp, fmt.Errorf("%w: connection not writable", ErrBadHandshake)
+	}
+	return conn, resp, nil
+}
+
+// websocketAccept computes the Sec-WebSocket-Accept value expected for key.
+func websocketAccept(key string) string {
+	h := sha1.Sum([]byte(key + websocketGUID))
+	return base64.StdEncoding.EncodeToString(h[:])
+}